@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// NodeChangePolicy decides whether a tool call that targets the active file
+// but a different node still re-opens the design to navigate to that node.
+type NodeChangePolicy string
+
+const (
+	// NodeChangeSkip never re-opens the active file, the node is only passed
+	// through to the tool call.
+	NodeChangeSkip NodeChangePolicy = "skip"
+	// NodeChangeNavigate re-opens the active file whenever the node changes.
+	NodeChangeNavigate NodeChangePolicy = "navigate"
+)
+
+func parseNodeChangePolicy(value string) (NodeChangePolicy, error) {
+	switch NodeChangePolicy(value) {
+	case "":
+		return NodeChangeSkip, nil
+	case NodeChangeSkip, NodeChangeNavigate:
+		return NodeChangePolicy(value), nil
+	default:
+		return "", fmt.Errorf("invalid node change policy %q, expected %q or %q", value, NodeChangeSkip, NodeChangeNavigate)
+	}
+}
+
+// activeDesign tracks the design that was last opened in Figma desktop so that
+// consecutive tool calls against the same document skip the open and launch
+// delay.
+type activeDesign struct {
+	mu     sync.Mutex
+	policy NodeChangePolicy
+	design util.Design
+	known  bool
+}
+
+func newActiveDesign(policy NodeChangePolicy) *activeDesign {
+	return &activeDesign{policy: policy}
+}
+
+// needsOpen reports whether the given design must be opened before the tool
+// call is forwarded.
+func (a *activeDesign) needsOpen(design util.Design) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.known || !a.design.SameDocument(design) {
+		return true
+	}
+	if a.design.NodeId != design.NodeId && a.policy == NodeChangeNavigate {
+		return true
+	}
+	return false
+}
+
+// get returns the design that is active in Figma desktop, as far as the proxy
+// knows.
+func (a *activeDesign) get() (util.Design, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.design, a.known
+}
+
+// set records the design that is now active in Figma desktop.
+func (a *activeDesign) set(design util.Design) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.design = design
+	a.known = true
+}
+
+// reset forgets the active design, forcing the next tool call to open its file.
+// It is used when opening fails and the state of Figma desktop is unknown.
+func (a *activeDesign) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.design = util.Design{}
+	a.known = false
+}
+
+func loadNodeChangePolicy() NodeChangePolicy {
+	value := os.Getenv("NODE_CHANGE_POLICY")
+	log.Printf("[MAIN] Environment variable NODE_CHANGE_POLICY: %q", value)
+	policy, err := parseNodeChangePolicy(value)
+	if err != nil {
+		log.Fatalf("[MAIN] %v", err)
+	}
+	log.Printf("[MAIN] Using node change policy: %s", policy)
+	return policy
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// TestActiveDesignSkipsOpen checks which tool calls open their design, for a
+// sequence of calls under each node change policy.
+func TestActiveDesignSkipsOpen(t *testing.T) {
+	const (
+		oneFirst  = "https://www.figma.com/design/fileOne/One?node-id=1-1"
+		oneSecond = "https://www.figma.com/design/fileOne/One?node-id=2-2"
+		two       = "https://www.figma.com/design/fileTwo/Two?node-id=3-3"
+	)
+	tests := []struct {
+		name   string
+		policy NodeChangePolicy
+		calls  []string
+		want   []string
+	}{
+		{
+			name:   "same design",
+			policy: NodeChangeSkip,
+			calls:  []string{oneFirst, oneFirst},
+			want:   []string{"fileOne 1:1"},
+		},
+		{
+			name:   "same file, other node",
+			policy: NodeChangeSkip,
+			calls:  []string{oneFirst, oneSecond},
+			want:   []string{"fileOne 1:1"},
+		},
+		{
+			name:   "other file",
+			policy: NodeChangeSkip,
+			calls:  []string{oneFirst, two, oneSecond},
+			want:   []string{"fileOne 1:1", "fileTwo 3:3", "fileOne 2:2"},
+		},
+		{
+			name:   "navigate to the same node",
+			policy: NodeChangeNavigate,
+			calls:  []string{oneFirst, oneFirst},
+			want:   []string{"fileOne 1:1"},
+		},
+		{
+			name:   "navigate to another node",
+			policy: NodeChangeNavigate,
+			calls:  []string{oneFirst, oneSecond, oneSecond},
+			want:   []string{"fileOne 1:1", "fileOne 2:2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opener := &loggingOpener{log: &eventLog{}}
+			cfg := newTestConfig(t, newJSONUpstream(t).URL, opener)
+			cfg.nodeChangePolicy = tt.policy
+			handler := newMCPHandler(cfg)
+
+			for i, figmaURL := range tt.calls {
+				resp := serveRPC(t, handler, newToolCallRequest(i+1, "get_code", map[string]interface{}{"figmaUrl": figmaURL}))
+				if resp.Error != nil {
+					t.Fatalf("call %d failed: %+v", i+1, resp.Error)
+				}
+			}
+			var opened []string
+			for _, design := range opener.Designs() {
+				opened = append(opened, design.FileKey+" "+design.NodeId)
+			}
+			if !reflect.DeepEqual(opened, tt.want) {
+				t.Errorf("opened %v, want %v", opened, tt.want)
+			}
+		})
+	}
+}
+
+// TestActiveDesignResetAfterFailedOpen checks that a failed open forgets the
+// active design, so that the next call for it opens it again.
+func TestActiveDesignResetAfterFailedOpen(t *testing.T) {
+	active := newActiveDesign(NodeChangeSkip)
+	design := util.Design{FileKey: "fileOne", NodeId: "1:1"}
+	if !active.needsOpen(design) {
+		t.Fatal("an unknown design does not need opening")
+	}
+	active.set(design)
+	if active.needsOpen(util.Design{FileKey: "fileOne", NodeId: "2:2"}) {
+		t.Error("the active file needs opening for another node under the skip policy")
+	}
+	active.reset()
+	if !active.needsOpen(design) {
+		t.Error("the design does not need opening after a reset")
+	}
+}
+
+func TestParseNodeChangePolicy(t *testing.T) {
+	for value, want := range map[string]NodeChangePolicy{"": NodeChangeSkip, "skip": NodeChangeSkip, "navigate": NodeChangeNavigate} {
+		if got, err := parseNodeChangePolicy(value); err != nil || got != want {
+			t.Errorf("parseNodeChangePolicy(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	if _, err := parseNodeChangePolicy("always"); err == nil {
+		t.Error("parseNodeChangePolicy accepted an unknown policy")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// defaultStripArguments are the tool arguments that only the proxy understands.
+var defaultStripArguments = []string{"figmaUrl", "fileKey", "fileName"}
+
+// loadStripArguments reads the comma separated STRIP_ARGUMENTS list of tool
+// arguments removed before a tools/call is forwarded upstream. Setting it to an
+// empty value forwards every argument.
+func loadStripArguments() []string {
+	value, set := os.LookupEnv("STRIP_ARGUMENTS")
+	log.Printf("[MAIN] Environment variable STRIP_ARGUMENTS: %q (set: %v)", value, set)
+	if !set {
+		log.Printf("[MAIN] Stripping default proxy arguments: %v", defaultStripArguments)
+		return defaultStripArguments
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	log.Printf("[MAIN] Stripping proxy arguments: %v", names)
+	return names
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// TestProxyArgumentsAreStripped checks the arguments a tool call reaches the
+// upstream with, and that the Content-Length of the rewritten body matches it.
+func TestProxyArgumentsAreStripped(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading the forwarded body: %v", err)
+		}
+		if r.ContentLength != int64(len(body)) || r.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+			t.Errorf("Content-Length %d (header %q) for a body of %d bytes", r.ContentLength, r.Header.Get("Content-Length"), len(body))
+		}
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Errorf("invalid forwarded body %q: %v", body, err)
+		}
+		mu.Lock()
+		forwarded = msg.Params.Arguments
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":`+string(msg.ID)+`,"result":{"content":[]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name      string
+		strip     []string
+		arguments map[string]interface{}
+		want      map[string]interface{}
+	}{
+		{
+			name:      "figmaUrl supplies the node",
+			strip:     defaultStripArguments,
+			arguments: map[string]interface{}{"figmaUrl": "https://www.figma.com/design/fileOne/One?node-id=1-1"},
+			want:      map[string]interface{}{"nodeId": "1:1"},
+		},
+		{
+			name:  "file arguments",
+			strip: defaultStripArguments,
+			arguments: map[string]interface{}{
+				"fileKey": "fileOne", "fileName": "One", "nodeId": "2:2", "clientLanguages": "typescript",
+			},
+			want: map[string]interface{}{"nodeId": "2:2", "clientLanguages": "typescript"},
+		},
+		{
+			name:      "nothing to strip",
+			strip:     defaultStripArguments,
+			arguments: map[string]interface{}{"nodeId": "3:3"},
+			want:      map[string]interface{}{"nodeId": "3:3"},
+		},
+		{
+			name:      "stripping turned off",
+			strip:     nil,
+			arguments: map[string]interface{}{"fileKey": "fileOne", "fileName": "One", "nodeId": "2:2"},
+			want:      map[string]interface{}{"fileKey": "fileOne", "fileName": "One", "nodeId": "2:2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+			cfg.stripArguments = tt.strip
+			resp := serveRPC(t, newMCPHandler(cfg), newToolCallRequest(1, "get_code", tt.arguments))
+			if resp.Error != nil {
+				t.Fatalf("call failed: %+v", resp.Error)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(forwarded, tt.want) {
+				t.Errorf("forwarded arguments %v, want %v", forwarded, tt.want)
+			}
+		})
+	}
+}
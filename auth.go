@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/oauth"
+)
+
+// keyStore serves the keys of the API_KEYS_FILE key store and the API_KEY
+// environment variable. The key file is reloaded whenever it changes, so that
+// keys created, revoked or rotated with the keys command apply without a
+// restart.
+type keyStore struct {
+	file   string
+	envKey *keystore.Key
+
+	mu      sync.Mutex
+	store   *keystore.Store
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+// loadKeyStore reads the key file named by API_KEYS_FILE and adds the API_KEY
+// environment variable as an admin key named "default". It returns nil when
+// neither is set, which turns authentication off.
+func loadKeyStore() *keyStore {
+	s := &keyStore{file: os.Getenv("API_KEYS_FILE")}
+	log.Printf("[MAIN] Environment variable API_KEYS_FILE: %q", s.file)
+	if secret := os.Getenv("API_KEY"); secret != "" {
+		s.envKey = &keystore.Key{Name: "default", Scopes: keystore.Scopes{Admin: true}}
+		if err := s.envKey.SetSecret(secret); err != nil {
+			log.Fatalf("[MAIN] Failed to hash API_KEY: %v", err)
+		}
+	}
+	if s.file == "" && s.envKey == nil {
+		log.Printf("[MAIN] API keys configured: 0")
+		return nil
+	}
+
+	s.store = s.withEnvKey(nil)
+	if s.file != "" {
+		if err := s.reload(); err != nil {
+			log.Fatalf("[MAIN] Failed to load API keys: %v", err)
+		}
+	}
+	log.Printf("[MAIN] API keys configured: %d", len(s.store.Keys))
+	for _, key := range s.store.Keys {
+		log.Printf("[MAIN] API key %q - admin: %v, restricted: %v, disabled: %v, expires: %v", key.Name, key.Scopes.Admin, key.Restricted(), key.Disabled, key.ExpiresAt)
+	}
+	return s
+}
+
+// authenticate returns the key of a secret.
+func (s *keyStore) authenticate(secret string) (*keystore.Key, error) {
+	return s.current().Authenticate(secret, time.Now())
+}
+
+// current returns the keys, reloading the key file first if it changed.
+func (s *keyStore) current() *keystore.Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != "" {
+		if err := s.reload(); err != nil {
+			log.Printf("[AUTH] ERROR: Failed to reload API keys, keeping the previous keys: %v", err)
+		}
+	}
+	return s.store
+}
+
+// reload reads the key file if it changed since it was last read. A missing
+// file holds no keys, until the keys command creates it. s.mu must be held or
+// the store not yet shared.
+func (s *keyStore) reload() error {
+	info, err := os.Stat(s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !s.missing {
+			log.Printf("[AUTH] Key file %s does not exist, no keys from it are accepted", s.file)
+		}
+		s.store, s.modTime, s.size, s.missing = s.withEnvKey(nil), time.Time{}, 0, true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.missing = false
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	// Remember the version even when it is invalid, so that it is reported
+	// once rather than on every request
+	s.modTime, s.size = info.ModTime(), info.Size()
+	loaded, err := keystore.Load(s.file)
+	if err != nil {
+		return err
+	}
+	s.store = s.withEnvKey(loaded.Keys)
+	log.Printf("[AUTH] Loaded %d keys from %s", len(loaded.Keys), s.file)
+	return nil
+}
+
+func (s *keyStore) withEnvKey(keys []*keystore.Key) *keystore.Store {
+	if s.envKey != nil {
+		keys = append(keys, s.envKey)
+	}
+	return &keystore.Store{Keys: keys}
+}
+
+// errNoToken is returned for requests without a Bearer token.
+var errNoToken = errors.New("no bearer token")
+
+// authenticator checks the Bearer token of /mcp requests against the static
+// API keys and, when OAuth is configured, as a JWT access token. Both result in
+// a key whose scopes limit the request.
+type authenticator struct {
+	keys  *keyStore
+	oauth *oauthResource
+}
+
+// enabled reports whether requests must authenticate at all.
+func (a *authenticator) enabled() bool {
+	return a.keys != nil || a.oauth != nil
+}
+
+// authenticate returns the key of a request. Disabled and expired API keys are
+// returned along with their error so that they can be logged by name.
+func (a *authenticator) authenticate(r *http.Request) (*keystore.Key, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoToken
+	}
+	if a.oauth != nil && (a.keys == nil || oauth.LooksLikeJWT(token)) {
+		return a.oauth.authenticate(r.Context(), token)
+	}
+	if a.keys == nil {
+		return nil, keystore.ErrUnknownKey
+	}
+	return a.keys.authenticate(token)
+}
+
+// reject answers a request that failed authentication with a JSON-RPC error.
+// With OAuth configured the WWW-Authenticate header points the client at the
+// resource metadata, and tokens lacking a required scope are refused with 403
+// Forbidden. The message does not tell unknown keys from disabled ones.
+func (a *authenticator) reject(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusUnauthorized
+	message := "invalid bearer token"
+	switch {
+	case errors.Is(err, errNoToken):
+		message = "missing bearer token"
+	case errors.Is(err, oauth.ErrInsufficientScope):
+		status = http.StatusForbidden
+		message = "insufficient scope"
+	}
+	if a.oauth != nil {
+		challengeErr := err
+		if errors.Is(err, errNoToken) {
+			challengeErr = nil
+		}
+		w.Header().Set("WWW-Authenticate", oauth.Challenge(a.oauth.metadataURL, challengeErr, a.oauth.validator.RequiredScopes))
+	}
+	// The body has not been read yet, so the request id is unknown
+	writeRPCErrorStatus(w, r, getRequestID(r), status, nil, codeUnauthorized, message)
+}
+
+type ctxKeyAPIKey struct{}
+
+func withAPIKey(ctx context.Context, key *keystore.Key) context.Context {
+	return context.WithValue(ctx, ctxKeyAPIKey{}, key)
+}
+
+// requestKey returns the key a request was authenticated with, nil when
+// authentication is off. The scope checks of a nil key allow everything.
+func requestKey(ctx context.Context) *keystore.Key {
+	key, _ := ctx.Value(ctxKeyAPIKey{}).(*keystore.Key)
+	return key
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/sse"
+)
+
+// bufferedResponseWriter collects a proxied response so that it can be merged
+// into a batch response.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header)}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Flush is a no-op, the body is only read once the response is complete.
+func (b *bufferedResponseWriter) Flush() {}
+
+// responseMessages extracts the JSON-RPC responses from a JSON or SSE body.
+// Server-initiated requests and notifications on the stream are dropped, they
+// cannot be represented in a batch response.
+func responseMessages(reqID string, header http.Header, body []byte) ([]*jsonrpc.Message, error) {
+	var payloads [][]byte
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		reader := sse.NewReader(bytes.NewReader(body))
+		for {
+			event, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if event.Data != "" {
+				payloads = append(payloads, []byte(event.Data))
+			}
+		}
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		payloads = append(payloads, body)
+	}
+
+	var msgs []*jsonrpc.Message
+	for _, data := range payloads {
+		payload, err := jsonrpc.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range payload.Messages {
+			if !msg.IsResponse() {
+				log.Printf("[BATCH] [%s] Dropping server message %q from batch response", reqID, msg.Method)
+				continue
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// serveBatch splits a JSON-RPC batch into one request per message so that every
+// tool call runs against its own design, then merges the responses back into a
+// single batch response. serve handles each single-message sub-request in turn,
+// holding the design file lock for file-bound ones.
+func serveBatch(w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter, *http.Request, *jsonrpc.Payload), payload *jsonrpc.Payload) {
+	reqID := getRequestID(r)
+	log.Printf("[BATCH] [%s] Splitting batch of %d messages into sub-requests", reqID, len(payload.Messages))
+
+	var responses []*jsonrpc.Message
+	var sessionID string
+	for i, msg := range payload.Messages {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("[BATCH] [%s] ERROR: Failed to marshal batch entry %d: %v", reqID, i, err)
+			responses = append(responses, jsonrpc.NewErrorResponse(msg.ID, jsonrpc.CodeInternalError, err.Error(), nil))
+			continue
+		}
+
+		sub := r.Clone(r.Context())
+		sub.Body = io.NopCloser(bytes.NewReader(body))
+		sub.ContentLength = int64(len(body))
+		sub.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		// Let the transport negotiate and decode compression, the sub-response
+		// body is parsed rather than passed through
+		sub.Header.Del("Accept-Encoding")
+		if sessionID != "" && sub.Header.Get("Mcp-Session-Id") == "" {
+			sub.Header.Set("Mcp-Session-Id", sessionID)
+		}
+
+		log.Printf("[BATCH] [%s] Forwarding batch entry %d - Method: %s, ID: %s", reqID, i, msg.Method, msg.ID)
+		rec := newBufferedResponseWriter()
+		serve(rec, sub, &jsonrpc.Payload{Messages: []*jsonrpc.Message{msg}})
+
+		if id := rec.header.Get("Mcp-Session-Id"); id != "" {
+			sessionID = id
+		}
+		if rec.status >= 300 {
+			log.Printf("[BATCH] [%s] Batch entry %d failed with status %d", reqID, i, rec.status)
+			// Errors answered by the proxy already carry a JSON-RPC error
+			if msgs, err := responseMessages(reqID, rec.header, rec.body.Bytes()); err == nil && len(msgs) > 0 {
+				if msg.IsRequest() {
+					responses = append(responses, msgs...)
+				}
+				continue
+			}
+			if msg.IsRequest() {
+				responses = append(responses, jsonrpc.NewErrorResponse(msg.ID, jsonrpc.CodeInternalError,
+					fmt.Sprintf("upstream returned status %d: %s", rec.status, strings.TrimSpace(rec.body.String())), nil))
+			}
+			continue
+		}
+
+		msgs, err := responseMessages(reqID, rec.header, rec.body.Bytes())
+		if err != nil {
+			log.Printf("[BATCH] [%s] ERROR: Failed to parse response to batch entry %d: %v", reqID, i, err)
+			if msg.IsRequest() {
+				responses = append(responses, jsonrpc.NewErrorResponse(msg.ID, jsonrpc.CodeInternalError, "invalid upstream response: "+err.Error(), nil))
+			}
+			continue
+		}
+		// An upstream may answer a notification with an error carrying a null
+		// id, which has no place in the batch response
+		if msg.IsRequest() {
+			responses = append(responses, msgs...)
+		}
+	}
+
+	if sessionID != "" {
+		w.Header().Set("Mcp-Session-Id", sessionID)
+	}
+	if len(responses) == 0 {
+		// A batch of notifications and responses gets no response body
+		log.Printf("[BATCH] [%s] Batch produced no responses", reqID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	merged, err := json.Marshal(responses)
+	if err != nil {
+		log.Printf("[BATCH] [%s] ERROR: Failed to marshal batch response: %v", reqID, err)
+		http.Error(w, "Failed to marshal batch response", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[BATCH] [%s] Responding with %d merged responses", reqID, len(responses))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(merged)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(merged)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newBatchUpstream returns an upstream that logs every message it receives as
+// "call:<id>", or "notify:<method>", and that answers notifications with a
+// null-id error, as some servers do.
+func newBatchUpstream(t *testing.T, events *eventLog) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid upstream request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if msg.ID == nil {
+			events.add("notify:" + msg.Method)
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32601,"message":"Method not found"}}`)
+			return
+		}
+		events.add("call:" + string(msg.ID))
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"content":[{"type":"text","text":"upstream"}]}}`, msg.ID)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func newBatchRequest(messages ...string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader("["+strings.Join(messages, ",")+"]"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	return req
+}
+
+func TestBatch(t *testing.T) {
+	events := &eventLog{}
+	upstream := newBatchUpstream(t, events)
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, &loggingOpener{log: events}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newBatchRequest(
+		`{"jsonrpc":"2.0","id":"first","method":"tools/call","params":{"name":"get_code","arguments":{"figmaUrl":"https://www.figma.com/design/fileOne/One?node-id=1-1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"other"}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"get_code","arguments":{"figmaUrl":"https://www.figma.com/design/fileTwo/Two?node-id=2-2"}}}`,
+	))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var responses []struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("invalid batch response %q: %v", rec.Body, err)
+	}
+	var ids []string
+	for _, resp := range responses {
+		ids = append(ids, string(resp.ID))
+		if resp.Error != nil {
+			t.Errorf("response %s is an error: %s", resp.ID, resp.Error)
+		}
+	}
+	if want := []string{`"first"`, `7`}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("response ids = %v, want %v", ids, want)
+	}
+
+	want := []string{"open:fileOne", `call:"first"`, "notify:notifications/cancelled", "open:fileTwo", "call:7"}
+	if got := events.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestBatchOfNotifications(t *testing.T) {
+	events := &eventLog{}
+	upstream := newBatchUpstream(t, events)
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, &loggingOpener{log: events}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newBatchRequest(
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`,
+	))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want none", rec.Body)
+	}
+	want := []string{"notify:notifications/initialized", "notify:notifications/cancelled"}
+	if got := events.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// defaultMaxBodySize is far above the size of any MCP request a client sends,
+// tool calls carry a few arguments.
+const defaultMaxBodySize = 4 << 20
+
+// loadMaxBodySize reads MAX_BODY_SIZE, the largest request body in bytes the
+// /mcp handler accepts.
+func loadMaxBodySize() int64 {
+	value := os.Getenv("MAX_BODY_SIZE")
+	log.Printf("[MAIN] Environment variable MAX_BODY_SIZE: %q", value)
+	if value == "" {
+		return defaultMaxBodySize
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		log.Fatalf("[MAIN] Invalid MAX_BODY_SIZE, expected a number of bytes: %q", value)
+	}
+	return size
+}
+
+// errBodyTooLarge is returned by readRequestBody for bodies above the limit.
+var errBodyTooLarge = errors.New("request body too large")
+
+// readRequestBody reads a whole request body of at most limit bytes, so that it
+// is buffered once and parsed by the /mcp handler. Bodies without a
+// Content-Length are cut off at the limit as well.
+func readRequestBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if r.ContentLength > limit {
+		return nil, fmt.Errorf("%w: Content-Length %d exceeds %d bytes", errBodyTooLarge, r.ContentLength, limit)
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, limit)
+	}
+	return body, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// TestRequestBodyLimit checks that bodies above MAX_BODY_SIZE are refused
+// before they reach the upstream, whether or not they declare their length.
+func TestRequestBodyLimit(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+	cfg.maxBodySize = 64
+	handler := newMCPHandler(cfg)
+
+	small := `{"jsonrpc":"2.0","id":1,"method":"ping"}`
+	large := `{"jsonrpc":"2.0","id":1,"method":"ping","params":{"padding":"` + strings.Repeat("x", 64) + `"}}`
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "within the limit", body: small, status: http.StatusOK},
+		{name: "chunked within the limit", body: small, chunked: true, status: http.StatusOK},
+		{name: "Content-Length over the limit", body: large, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked over the limit", body: large, chunked: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json, text/event-stream")
+			if tt.chunked {
+				// Hide the length, as for a request with Transfer-Encoding: chunked
+				req.Body = io.NopCloser(io.MultiReader(strings.NewReader(tt.body)))
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				if calls.Load() != 1 {
+					t.Errorf("upstream called %d times, want once", calls.Load())
+				}
+				return
+			}
+			if calls.Load() != 0 {
+				t.Errorf("upstream called %d times, want no calls", calls.Load())
+			}
+			want := `{"jsonrpc":"2.0","id":null,"error":{"code":-32003,"message":"request body exceeds the limit of 64 bytes"}}` + "\n"
+			if rec.Body.String() != want {
+				t.Errorf("body = %s, want %s", rec.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// decodableEncodings are the content codings the proxy can decode before
+// rewriting a response. Brotli is not in the standard library, so it is never
+// requested from the upstream.
+var decodableEncodings = map[string]bool{
+	"gzip":     true,
+	"x-gzip":   true,
+	"deflate":  true,
+	"identity": true,
+}
+
+// restrictAcceptEncoding limits the Accept-Encoding sent upstream to codings the
+// proxy can decode. When none of the client's codings are usable the header is
+// removed, which lets the transport negotiate and transparently decode gzip.
+func restrictAcceptEncoding(reqID string, req *http.Request) {
+	accept := req.Header.Get("Accept-Encoding")
+	if accept == "" {
+		return
+	}
+	var kept []string
+	for _, part := range strings.Split(accept, ",") {
+		coding := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if decodableEncodings[coding] {
+			kept = append(kept, strings.TrimSpace(part))
+		}
+	}
+	if len(kept) == 0 {
+		log.Printf("[DIRECTOR] [%s] Removing unsupported Accept-Encoding %q", reqID, accept)
+		req.Header.Del("Accept-Encoding")
+		return
+	}
+	restricted := strings.Join(kept, ", ")
+	if restricted != accept {
+		log.Printf("[DIRECTOR] [%s] Restricting Accept-Encoding %q to %q", reqID, accept, restricted)
+	}
+	req.Header.Set("Accept-Encoding", restricted)
+}
+
+// decodeResponseBody replaces a compressed response body with its decoded form
+// and removes Content-Encoding and Content-Length, which no longer apply.
+func decodeResponseBody(reqID string, resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var decoded io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("decode gzip response: %w", err)
+		}
+		decoded = gz
+	case "deflate":
+		decoded = newDeflateReader(resp.Body)
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+
+	log.Printf("[MODIFY_RESPONSE] [%s] Decoding %s response body before rewriting", reqID, encoding)
+	resp.Body = &decodedBody{Reader: decoded, decoder: decoded, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// newDeflateReader reads an HTTP deflate body, which is meant to be zlib-wrapped
+// but is sent as raw deflate by some servers.
+func newDeflateReader(r io.Reader) io.ReadCloser {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		if zr, err := zlib.NewReader(br); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(br)
+}
+
+// decodedBody closes both the decoder and the underlying response body.
+type decodedBody struct {
+	io.Reader
+	decoder io.Closer
+	body    io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	d.decoder.Close()
+	return d.body.Close()
+}
+
+// compressibleTypes are the media types compressed for clients.
+var compressibleTypes = map[string]bool{
+	"application/json":  true,
+	"text/event-stream": true,
+	"text/plain":        true,
+}
+
+// withCompression gzips responses for clients that advertise gzip in
+// Accept-Encoding. Event streams are flushed through the compressor so that
+// events still reach the client as they are produced.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, reqID: getRequestID(r)}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if strings.ToLower(strings.TrimSpace(fields[0])) != "gzip" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if strings.ReplaceAll(strings.TrimSpace(param), " ", "") == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter decides when the header is written whether the response
+// is compressed, so that already encoded or unsuitable responses pass through.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	reqID       string
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.Header()
+	h.Add("Vary", "Accept-Encoding")
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if h.Get("Content-Encoding") == "" && compressibleTypes[mediaType] &&
+		status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK {
+		log.Printf("[COMPRESS] [%s] Compressing %s response with gzip", g.reqID, mediaType)
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush pushes buffered compressed data to the client.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		if err := g.gz.Close(); err != nil {
+			log.Printf("[COMPRESS] [%s] ERROR: Failed to finish gzip stream: %v", g.reqID, err)
+		}
+	}
+}
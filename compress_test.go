@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+func TestRestrictAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "gzip, deflate, br", want: "gzip, deflate"},
+		{accept: "br;q=1.0, GZIP;q=0.5, zstd", want: "GZIP;q=0.5"},
+		{accept: "x-gzip,identity", want: "x-gzip, identity"},
+		{accept: "br, zstd", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			restrictAcceptEncoding("test", req)
+			if got := req.Header.Get("Accept-Encoding"); got != tt.want {
+				t.Errorf("Accept-Encoding = %q, want %q", got, tt.want)
+			}
+			if _, ok := req.Header["Accept-Encoding"]; !ok && tt.want != "" {
+				t.Error("Accept-Encoding was removed")
+			}
+		})
+	}
+}
+
+func compress(t *testing.T, encoding string, data string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "zlib":
+		w = zlib.NewWriter(&b)
+	case "raw deflate":
+		w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+	default:
+		return []byte(data)
+	}
+	io.WriteString(w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	const body = `{"jsonrpc":"2.0","id":1,"result":{"tools":[]}}`
+	tests := []struct {
+		name     string
+		encoding string
+		coding   string
+	}{
+		{name: "gzip", encoding: "gzip", coding: "gzip"},
+		{name: "x-gzip", encoding: "x-gzip", coding: "gzip"},
+		{name: "zlib deflate", encoding: "deflate", coding: "zlib"},
+		{name: "raw deflate", encoding: "Deflate", coding: "raw deflate"},
+		{name: "identity", encoding: "identity"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := compress(t, tt.coding, body)
+			resp := &http.Response{
+				Header:        http.Header{"Content-Length": {strconv.Itoa(len(encoded))}},
+				Body:          io.NopCloser(bytes.NewReader(encoded)),
+				ContentLength: int64(len(encoded)),
+			}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if err := decodeResponseBody("test", resp); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+			if tt.coding == "" {
+				return
+			}
+			if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 {
+				t.Errorf("Content-Encoding %q, Content-Length %q (%d) left on the decoded response",
+					resp.Header.Get("Content-Encoding"), resp.Header.Get("Content-Length"), resp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestDecodeResponseBodyErrors(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip"} {
+		resp := &http.Response{
+			Header: http.Header{"Content-Encoding": {encoding}},
+			Body:   io.NopCloser(strings.NewReader("not compressed")),
+		}
+		if err := decodeResponseBody("test", resp); err == nil {
+			t.Errorf("%s: decoding an invalid body succeeded", encoding)
+		}
+		if resp.Header.Get("Content-Encoding") != encoding {
+			t.Errorf("%s: Content-Encoding removed from a body that was not decoded", encoding)
+		}
+	}
+}
+
+// TestCompressedToolsList checks that a gzipped tools/list response is decoded
+// before it is rewritten, and that the client gets it compressed again.
+func TestCompressedToolsList(t *testing.T) {
+	var acceptEncoding string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		body := compress(t, "gzip", `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_code"}]}}`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	t.Cleanup(upstream.Close)
+	handler := withCompression(newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{})))
+
+	req := newToolsListRequest("1")
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if acceptEncoding != "gzip" {
+		t.Errorf("upstream Accept-Encoding = %q, want gzip", acceptEncoding)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d, Content-Encoding %q, want a gzipped 200", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"get_code", "open_figma_design", "get_active_design", "parse_figma_url", "set_current_design"}
+	if got := listedTools(t, body); !reflect.DeepEqual(got, want) {
+		t.Errorf("tools = %v, want %v", got, want)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP":     true,
+		"gzip;q=0.5":        true,
+		"gzip;q=0":          false,
+		"gzip; q = 0, br":   false,
+		"br, x-gzip, zstd":  false,
+		"identity;q=1, br":  false,
+		"br;q=0, gzip;q=1 ": true,
+	}
+	for accept, want := range tests {
+		if got := acceptsGzip(accept); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+// TestCompressionFlushesEvents checks that a compressed event stream reaches
+// the client event by event rather than when the response ends.
+func TestCompressionFlushesEvents(t *testing.T) {
+	next := make(chan struct{})
+	server := httptest.NewServer(withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: message\ndata: first\n\n")
+		w.(http.Flusher).Flush()
+		<-next
+		io.WriteString(w, "event: message\ndata: second\n\n")
+	})))
+	t.Cleanup(server.Close)
+	defer func() {
+		select {
+		case <-next:
+		default:
+			close(next)
+		}
+	}()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(gz)
+	readEvent := func() string {
+		var event strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading event: %v", err)
+			}
+			if line == "\n" {
+				return event.String()
+			}
+			event.WriteString(line)
+		}
+	}
+	// The handler is still blocked, so the first event must have been flushed
+	if got := readEvent(); got != "event: message\ndata: first\n" {
+		t.Errorf("first event = %q", got)
+	}
+	close(next)
+	if got := readEvent(); got != "event: message\ndata: second\n" {
+		t.Errorf("second event = %q", got)
+	}
+}
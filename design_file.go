@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// designFileLock serializes file-bound tool calls. Figma desktop only has one
+// active file, so the lock is held from the moment the design is opened until
+// the upstream response has been fully streamed back to the client. It is a
+// semaphore rather than a mutex so that waiting gives up when the client does.
+var designFileLock = make(chan struct{}, 1)
+
+// acquireDesignFileLock waits for designFileLock, or returns the context's
+// error when it is done first.
+func acquireDesignFileLock(ctx context.Context) error {
+	select {
+	case designFileLock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseDesignFileLock() {
+	<-designFileLock
+}
+
+// defaultLockWriteTimeout is how long a single write to the client may take
+// while the design file lock is held.
+const defaultLockWriteTimeout = 30 * time.Second
+
+// deadlineWriter refreshes the write deadline before every write and flush, so
+// that a client that stops reading its response fails the write after timeout
+// and the design file lock is released, while a stream the client keeps
+// reading may stay open for as long as it lasts.
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	reqID   string
+	timeout time.Duration
+}
+
+func newDeadlineWriter(w http.ResponseWriter, reqID string, timeout time.Duration) *deadlineWriter {
+	return &deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w), reqID: reqID, timeout: timeout}
+}
+
+func (w *deadlineWriter) refresh() {
+	if err := w.rc.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("[LOCK] [%s] ERROR: Failed to set the write deadline: %v", w.reqID, err)
+	}
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.refresh()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) Flush() {
+	w.refresh()
+	w.rc.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// designFileTarget reports the Figma design a JSON-RPC message is bound to.
+// Only tools/call requests are file-bound: a figmaUrl argument takes precedence,
+// otherwise a fileKey argument binds the call, with optional fileName and
+// nodeId arguments as in util.Design. An error is returned for a figmaUrl that
+// is not a Figma link.
+func designFileTarget(msg *jsonrpc.Message) (util.Design, bool, error) {
+	call, isCall, err := msg.ToolCall()
+	if !isCall || err != nil {
+		return util.Design{}, false, nil
+	}
+
+	nodeId, nodeIdExists := call.StringArgument("nodeId")
+	if figmaURL, ok := call.StringArgument("figmaUrl"); ok && figmaURL != "" {
+		link, err := figmaurl.Parse(figmaURL)
+		if err != nil {
+			return util.Design{}, true, fmt.Errorf("invalid figmaUrl: %w", err)
+		}
+		design := util.DesignFromLink(link)
+		if nodeIdExists {
+			design.NodeId = nodeId
+		}
+		return design, true, nil
+	}
+
+	fileKey, fileKeyExists := call.StringArgument("fileKey")
+	if !fileKeyExists {
+		return util.Design{}, false, nil
+	}
+	if fileKey == "" {
+		return util.Design{}, true, fmt.Errorf("fileKey must not be empty")
+	}
+	fileName, _ := call.StringArgument("fileName")
+	return util.Design{FileKey: fileKey, FileName: fileName, NodeId: nodeId}, true, nil
+}
+
+// lockDesignFile acquires designFileLock when the payload contains a file-bound
+// tool call and returns the matching unlock function. The returned function is
+// a no-op for requests that are not bound to a file. It returns the context's
+// error when the request is canceled while waiting.
+func lockDesignFile(ctx context.Context, reqID string, payload *jsonrpc.Payload) (func(), error) {
+	var design util.Design
+	bound := false
+	for _, msg := range payload.Messages {
+		if d, ok, err := designFileTarget(msg); ok && err == nil {
+			design, bound = d, true
+			break
+		}
+	}
+	if !bound {
+		return func() {}, nil
+	}
+	log.Printf("[LOCK] [%s] Waiting for design file lock for %s", reqID, design)
+	if err := acquireDesignFileLock(ctx); err != nil {
+		log.Printf("[LOCK] [%s] Gave up waiting for design file lock for %s: %v", reqID, design, err)
+		return nil, err
+	}
+	log.Printf("[LOCK] [%s] Lock ACQUIRED for %s", reqID, design)
+	return func() {
+		releaseDesignFileLock()
+		log.Printf("[LOCK] [%s] Lock RELEASED for %s", reqID, design)
+	}, nil
+}
+
+// OpenFailurePolicy decides what happens to a tool call whose design could not
+// be opened in Figma desktop.
+type OpenFailurePolicy string
+
+const (
+	// OpenFailureStrict answers the tool call with a JSON-RPC error instead of
+	// running it against whatever file happens to be open.
+	OpenFailureStrict OpenFailurePolicy = "strict"
+	// OpenFailureLenient forwards the tool call and adds a warning to its result.
+	OpenFailureLenient OpenFailurePolicy = "lenient"
+)
+
+func parseOpenFailurePolicy(value string) (OpenFailurePolicy, error) {
+	switch OpenFailurePolicy(value) {
+	case "":
+		return OpenFailureStrict, nil
+	case OpenFailureStrict, OpenFailureLenient:
+		return OpenFailurePolicy(value), nil
+	default:
+		return "", fmt.Errorf("invalid open failure policy %q, expected %q or %q", value, OpenFailureStrict, OpenFailureLenient)
+	}
+}
+
+func loadOpenFailurePolicy() OpenFailurePolicy {
+	value := os.Getenv("OPEN_FAILURE_POLICY")
+	log.Printf("[MAIN] Environment variable OPEN_FAILURE_POLICY: %q", value)
+	policy, err := parseOpenFailurePolicy(value)
+	if err != nil {
+		log.Fatalf("[MAIN] %v", err)
+	}
+	log.Printf("[MAIN] Using open failure policy: %s", policy)
+	return policy
+}
+
+// designSwitcher makes a design the active file in Figma desktop. Callers must
+// hold designFileLock.
+type designSwitcher struct {
+	opener util.Opener
+	active *activeDesign
+	waiter *util.ReadinessWaiter
+}
+
+// ensureOpen opens the design unless it is already active and waits for Figma
+// to report it ready.
+func (s *designSwitcher) ensureOpen(ctx context.Context, reqID string, design util.Design) error {
+	if !s.active.needsOpen(design) {
+		log.Printf("[DIRECTOR] [%s] Design already active, skipping open: %s", reqID, design)
+		return nil
+	}
+	if err := s.opener.Open(ctx, design); err != nil {
+		log.Printf("[DIRECTOR] [%s] ERROR: Failed to open Figma design: %v", reqID, err)
+		s.active.reset()
+		return err
+	}
+	// A branch is open under its own key
+	fileKey := design.FileKey
+	if design.BranchKey != "" {
+		fileKey = design.BranchKey
+	}
+	if err := s.waiter.Wait(ctx, fileKey, design.NodeId); err != nil {
+		log.Printf("[DIRECTOR] [%s] ERROR: Figma design did not become active: %v", reqID, err)
+		s.active.reset()
+		return err
+	}
+	log.Printf("[DIRECTOR] [%s] Successfully opened Figma design: %s", reqID, design)
+	s.active.set(design)
+	return nil
+}
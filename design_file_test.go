@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+func TestDesignFileTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		design    util.Design
+		bound     bool
+		wantErr   bool
+	}{
+		{
+			name:      "fileKey, fileName and nodeId",
+			arguments: map[string]interface{}{"fileKey": "abc123", "fileName": "My-File", "nodeId": "1:2"},
+			design:    util.Design{FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+			bound:     true,
+		},
+		{
+			name:      "fileKey and fileName without nodeId",
+			arguments: map[string]interface{}{"fileKey": "abc123", "fileName": "My-File"},
+			design:    util.Design{FileKey: "abc123", FileName: "My-File"},
+			bound:     true,
+		},
+		{
+			name:      "fileKey alone",
+			arguments: map[string]interface{}{"fileKey": "abc123"},
+			design:    util.Design{FileKey: "abc123"},
+			bound:     true,
+		},
+		{
+			name:      "empty fileKey",
+			arguments: map[string]interface{}{"fileKey": "", "fileName": "My-File"},
+			bound:     true,
+			wantErr:   true,
+		},
+		{
+			name:      "figmaUrl without node",
+			arguments: map[string]interface{}{"figmaUrl": "https://www.figma.com/design/abc123/My-File"},
+			design:    util.Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File"},
+			bound:     true,
+		},
+		{
+			name:      "nodeId overrides the figmaUrl node",
+			arguments: map[string]interface{}{"figmaUrl": "https://www.figma.com/design/abc123/My-File?node-id=1-2", "nodeId": "3:4"},
+			design:    util.Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "3:4"},
+			bound:     true,
+		},
+		{
+			name:      "invalid figmaUrl",
+			arguments: map[string]interface{}{"figmaUrl": "https://example.com/design/abc123"},
+			bound:     true,
+			wantErr:   true,
+		},
+		{
+			name:      "nodeId alone",
+			arguments: map[string]interface{}{"nodeId": "1:2"},
+		},
+		{
+			name:      "fileName alone",
+			arguments: map[string]interface{}{"fileName": "My-File", "nodeId": "1:2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, _ := json.Marshal(map[string]interface{}{"name": "get_code", "arguments": tt.arguments})
+			msg := &jsonrpc.Message{JSONRPC: jsonrpc.Version, ID: jsonrpc.NumberID(1), Method: jsonrpc.MethodToolsCall, Params: params}
+
+			design, bound, err := designFileTarget(msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("designFileTarget() error = %v, want error %v", err, tt.wantErr)
+			}
+			if bound != tt.bound {
+				t.Errorf("designFileTarget() bound = %v, want %v", bound, tt.bound)
+			}
+			if err == nil && design != tt.design {
+				t.Errorf("designFileTarget() design = %+v, want %+v", design, tt.design)
+			}
+		})
+	}
+}
+
+func TestDesignFileTargetIgnoresOtherMethods(t *testing.T) {
+	msg := &jsonrpc.Message{JSONRPC: jsonrpc.Version, ID: jsonrpc.NumberID(1), Method: jsonrpc.MethodToolsList}
+	if _, bound, err := designFileTarget(msg); bound || err != nil {
+		t.Errorf("designFileTarget(tools/list) = bound %v, error %v, want unbound", bound, err)
+	}
+}
+
+// TestLenientOpenFailure checks that OPEN_FAILURE_POLICY=lenient forwards a
+// call whose design failed to open with a warning, except for keys limited to
+// some files, which could otherwise read whatever file is open in Figma.
+func TestLenientOpenFailure(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var msg struct {
+			ID json.RawMessage `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"content":[{"type":"text","text":"upstream"}]}}`, msg.ID)
+	}))
+	defer upstream.Close()
+
+	opener := &failingOpener{fail: map[string]bool{"abc123": true}}
+	cfg := newTestConfig(t, upstream.URL, opener)
+	cfg.openFailurePolicy = OpenFailureLenient
+	handler := newMCPHandler(cfg)
+
+	tests := []struct {
+		name string
+		key  *keystore.Key
+		code int
+	}{
+		{name: "no key"},
+		{name: "key limited to some tools", key: &keystore.Key{Name: "tools", Scopes: keystore.Scopes{Tools: &keystore.ToolPolicy{Deny: []string{"get_image"}}}}},
+		{name: "admin key with files", key: &keystore.Key{Name: "admin", Scopes: keystore.Scopes{Files: []string{"abc*"}, Admin: true}}},
+		{name: "key limited to some files", key: &keystore.Key{Name: "files", Scopes: keystore.Scopes{Files: []string{"abc*"}}}, code: codeDesignOpenFailed},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&calls)
+			req := newToolCallRequest(i+1, "get_code", map[string]interface{}{"fileKey": "abc123", "fileName": "My-File", "nodeId": "1:2"})
+			req.Header.Set("Mcp-Session-Id", "session-1")
+			if tt.key != nil {
+				req = req.WithContext(withAPIKey(req.Context(), tt.key))
+			}
+			resp := serveRPC(t, handler, req)
+			forwarded := atomic.LoadInt32(&calls) > before
+
+			if tt.code != 0 {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Errorf("response = result %+v, error %+v, want error %d", resp.Result, resp.Error, tt.code)
+				}
+				if forwarded {
+					t.Error("call was forwarded although its design failed to open")
+				}
+				return
+			}
+			if resp.Result == nil || !forwarded {
+				t.Fatalf("response = error %+v, forwarded %v, want a forwarded call", resp.Error, forwarded)
+			}
+			content := resp.Result.Content
+			if len(content) != 2 || !strings.HasPrefix(content[0].Text, "Warning: the proxy failed to open https://www.figma.com/design/abc123/My-File") || content[1].Text != "upstream" {
+				t.Errorf("content = %+v, want the warning before the upstream result", content)
+			}
+		})
+	}
+
+	// A design that failed to open never becomes the session's current design
+	resp := serveRPC(t, handler, func() *http.Request {
+		req := newToolCallRequest(100, "get_active_design", nil)
+		req.Header.Set("Mcp-Session-Id", "session-1")
+		return req
+	}())
+	if resp.Result == nil || strings.Contains(string(resp.Result.StructuredContent), `"session":{`) {
+		t.Errorf("get_active_design = %+v, want no session design", resp.Result)
+	}
+}
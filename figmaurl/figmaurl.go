@@ -0,0 +1,125 @@
+// Package figmaurl parses the links people share to Figma files, such as
+// https://www.figma.com/design/{fileKey}/{fileName}?node-id=1-2, into the
+// file key, file slug, branch key and node ID they refer to.
+package figmaurl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// LinkType is the kind of document or view a link points to.
+type LinkType string
+
+const (
+	// Design is a Figma Design file, including legacy /file/ links
+	Design LinkType = "design"
+	// Proto is a prototype presentation of a Design file
+	Proto LinkType = "proto"
+	// Board is a FigJam board
+	Board LinkType = "board"
+	// Slides is a Figma Slides deck
+	Slides LinkType = "slides"
+	// Make is a Figma Make file
+	Make LinkType = "make"
+)
+
+// pathTypes maps the first path segment of a link to its type.
+var pathTypes = map[string]LinkType{
+	"design": Design,
+	"file":   Design,
+	"proto":  Proto,
+	"board":  Board,
+	"slides": Slides,
+	"deck":   Slides,
+	"make":   Make,
+}
+
+// Link is a parsed Figma link.
+type Link struct {
+	Type LinkType
+	// FileKey is the key of the file, or of the main file for branch links
+	FileKey string
+	// FileName is the URL slug of the file name, which may be empty
+	FileName string
+	// BranchKey is the key of the branch for /branch/ links
+	BranchKey string
+	// NodeId is the node from the node-id query parameter in API form, e.g. 1:2
+	NodeId string
+}
+
+// ErrNotFigmaURL is wrapped by Parse errors for links that are not Figma links.
+var ErrNotFigmaURL = errors.New("not a Figma URL")
+
+// Parse parses a https://figma.com or figma:// link. Embed links are unwrapped
+// to the link they embed.
+func Parse(raw string) (*Link, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotFigmaURL, err)
+	}
+
+	var segments []string
+	switch strings.ToLower(u.Scheme) {
+	case "https", "http":
+		host := strings.ToLower(u.Hostname())
+		if host != "figma.com" && !strings.HasSuffix(host, ".figma.com") {
+			return nil, fmt.Errorf("%w: unexpected host %q", ErrNotFigmaURL, u.Host)
+		}
+		segments = splitPath(u.Path)
+		if len(segments) == 1 && segments[0] == "embed" {
+			embedded := u.Query().Get("url")
+			if embedded == "" {
+				return nil, fmt.Errorf("%w: embed link without a url parameter", ErrNotFigmaURL)
+			}
+			return Parse(embedded)
+		}
+	case "figma":
+		// figma://design/{fileKey}/{fileName}, the first segment is the host
+		segments = append([]string{u.Host}, splitPath(u.Path)...)
+	default:
+		return nil, fmt.Errorf("%w: unexpected scheme %q", ErrNotFigmaURL, u.Scheme)
+	}
+
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("%w: missing file key in %q", ErrNotFigmaURL, raw)
+	}
+	linkType, ok := pathTypes[strings.ToLower(segments[0])]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported link type %q", ErrNotFigmaURL, segments[0])
+	}
+
+	link := &Link{Type: linkType, FileKey: segments[1]}
+	rest := segments[2:]
+	if len(rest) >= 2 && rest[0] == "branch" {
+		link.BranchKey = rest[1]
+		rest = rest[2:]
+	}
+	if len(rest) > 0 {
+		link.FileName = rest[0]
+	}
+	if nodeId := u.Query().Get("node-id"); nodeId != "" {
+		link.NodeId = NormalizeNodeId(nodeId)
+	}
+	return link, nil
+}
+
+// splitPath splits a URL path into its non-empty segments, keeping any
+// percent-encoding of the file name intact.
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, url.PathEscape(segment))
+		}
+	}
+	return segments
+}
+
+// NormalizeNodeId converts a node ID from URL form (1-2) to API form (1:2).
+func NormalizeNodeId(nodeId string) string {
+	return strings.ReplaceAll(nodeId, "-", ":")
+}
@@ -0,0 +1,113 @@
+package figmaurl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want Link
+	}{
+		{
+			name: "design",
+			url:  "https://www.figma.com/design/abc123/My-File?node-id=1-2&t=xyz",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+		},
+		{
+			name: "design without node",
+			url:  "https://figma.com/design/abc123/My-File",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File"},
+		},
+		{
+			name: "design without file name",
+			url:  "https://www.figma.com/design/abc123",
+			want: Link{Type: Design, FileKey: "abc123"},
+		},
+		{
+			name: "legacy file",
+			url:  "https://www.figma.com/file/abc123/My-File?node-id=1%3A2",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+		},
+		{
+			name: "branch",
+			url:  "https://www.figma.com/design/abc123/branch/def456/My-File?node-id=3-4",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File", BranchKey: "def456", NodeId: "3:4"},
+		},
+		{
+			name: "proto",
+			url:  "https://www.figma.com/proto/abc123/My-Prototype?node-id=5-6&scaling=min-zoom",
+			want: Link{Type: Proto, FileKey: "abc123", FileName: "My-Prototype", NodeId: "5:6"},
+		},
+		{
+			name: "board",
+			url:  "https://www.figma.com/board/abc123/My-Board?node-id=0-1",
+			want: Link{Type: Board, FileKey: "abc123", FileName: "My-Board", NodeId: "0:1"},
+		},
+		{
+			name: "slides",
+			url:  "https://www.figma.com/slides/abc123/My-Deck?node-id=7-8",
+			want: Link{Type: Slides, FileKey: "abc123", FileName: "My-Deck", NodeId: "7:8"},
+		},
+		{
+			name: "legacy deck",
+			url:  "https://www.figma.com/deck/abc123/My-Deck",
+			want: Link{Type: Slides, FileKey: "abc123", FileName: "My-Deck"},
+		},
+		{
+			name: "make",
+			url:  "https://www.figma.com/make/abc123/My-App?node-id=0-1",
+			want: Link{Type: Make, FileKey: "abc123", FileName: "My-App", NodeId: "0:1"},
+		},
+		{
+			name: "embed",
+			url:  "https://embed.figma.com/embed?embed_host=share&url=https%3A%2F%2Fwww.figma.com%2Fdesign%2Fabc123%2FMy-File%3Fnode-id%3D1-2",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+		},
+		{
+			name: "deep link",
+			url:  "figma://design/abc123/My-File?node-id=1-2",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+		},
+		{
+			name: "percent-encoded file name",
+			url:  "https://www.figma.com/design/abc123/My%20File",
+			want: Link{Type: Design, FileKey: "abc123", FileName: "My%20File"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := Parse(tt.url)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.url, err)
+			}
+			if *link != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.url, *link, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "other host", url: "https://example.com/design/abc123/My-File"},
+		{name: "lookalike host", url: "https://notfigma.com/design/abc123/My-File"},
+		{name: "other scheme", url: "ftp://www.figma.com/design/abc123/My-File"},
+		{name: "missing file key", url: "https://www.figma.com/design"},
+		{name: "unsupported type", url: "https://www.figma.com/community/file/123"},
+		{name: "embed without url", url: "https://www.figma.com/embed?embed_host=share"},
+		{name: "not a URL", url: "://"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if link, err := Parse(tt.url); !errors.Is(err, ErrNotFigmaURL) {
+				t.Errorf("Parse(%q) = %+v, %v, want ErrNotFigmaURL", tt.url, link, err)
+			}
+		})
+	}
+}
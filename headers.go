@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// defaultForwardHeaders are the client request headers the upstream Figma MCP
+// server needs. Everything else, including the client's Authorization header,
+// stays inside the proxy.
+var defaultForwardHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Content-Type",
+	"Last-Event-ID",
+	"Mcp-Protocol-Version",
+	"Mcp-Session-Id",
+	"User-Agent",
+}
+
+// headerPolicy decides which request headers reach the upstream server.
+type headerPolicy struct {
+	// forward holds the canonical names of the headers passed through
+	forward map[string]bool
+	// inject is set on every upstream request, replacing client values
+	inject http.Header
+}
+
+// loadHeaderPolicy reads the comma separated FORWARD_HEADERS allowlist and the
+// UPSTREAM_HEADERS JSON object of headers to add to every upstream request,
+// such as {"Authorization": "Bearer ..."}.
+func loadHeaderPolicy() *headerPolicy {
+	policy := &headerPolicy{forward: map[string]bool{}, inject: http.Header{}}
+
+	names := defaultForwardHeaders
+	if value, set := os.LookupEnv("FORWARD_HEADERS"); set {
+		log.Printf("[MAIN] Environment variable FORWARD_HEADERS: %q", value)
+		names = splitList(value)
+	}
+	for _, name := range names {
+		policy.forward[http.CanonicalHeaderKey(name)] = true
+	}
+	log.Printf("[MAIN] Forwarding request headers upstream: %v", names)
+
+	if value := os.Getenv("UPSTREAM_HEADERS"); value != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(value), &headers); err != nil {
+			log.Fatalf("[MAIN] Invalid UPSTREAM_HEADERS, expected a JSON object of header values: %v", err)
+		}
+		for name, value := range headers {
+			policy.inject.Set(name, value)
+		}
+		// Only the names, the values may be credentials
+		log.Printf("[MAIN] Adding headers to upstream requests: %v", headerNames(policy.inject))
+	}
+	return policy
+}
+
+// apply removes the headers that are not forwarded and adds the injected ones.
+// It returns the names of the removed headers.
+func (p *headerPolicy) apply(header http.Header) []string {
+	var removed []string
+	for name := range header {
+		if !p.forward[http.CanonicalHeaderKey(name)] {
+			removed = append(removed, name)
+			header.Del(name)
+		}
+	}
+	for name, values := range p.inject {
+		header[name] = append([]string(nil), values...)
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// headerPolicyTransport applies the header policy to every request sent
+// upstream.
+type headerPolicyTransport struct {
+	policy *headerPolicy
+	next   http.RoundTripper
+}
+
+func (t *headerPolicyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	if removed := t.policy.apply(out.Header); len(removed) > 0 {
+		log.Printf("[HEADERS] [%s] Not forwarding request headers upstream: %v", getRequestID(req), removed)
+	}
+	return t.next.RoundTrip(out)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestHeaderPolicy checks what reaches the upstream through the header policy
+// transport, for proxied calls and for the readiness probe alike.
+func TestHeaderPolicy(t *testing.T) {
+	t.Setenv("FORWARD_HEADERS", "")
+	os.Unsetenv("FORWARD_HEADERS")
+	t.Setenv("UPSTREAM_HEADERS", `{"Authorization":"Bearer upstream-token","X-Team":"design"}`)
+
+	var mu sync.Mutex
+	seen := map[string]http.Header{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     *int   `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid upstream request: %v", err)
+		}
+		mu.Lock()
+		seen[msg.Method] = r.Header.Clone()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch msg.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "probe-session")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":{}}`, *msg.ID)
+		case "notifications/initialized":
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":{"content":[{"type":"text","text":"ok"}]}}`, *msg.ID)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := newTestConfig(t, upstream.URL, nil)
+	cfg.transport = &headerPolicyTransport{policy: loadHeaderPolicy(), next: http.DefaultTransport}
+	handler := newMCPHandler(cfg)
+
+	req := newToolCallRequest(1, "get_code_connect_map", map[string]interface{}{})
+	req.Header.Set("Authorization", "Bearer client-secret")
+	req.Header.Set("Cookie", "session=client")
+	req.Header.Set("X-Internal-Trace", "client-trace")
+	req.Header.Set("Mcp-Session-Id", "client-session")
+	if resp := serveRPC(t, handler, req); resp.Error != nil {
+		t.Fatalf("tool call failed: %+v", resp.Error)
+	}
+
+	mu.Lock()
+	call := seen["tools/call"]
+	mu.Unlock()
+	if call == nil {
+		t.Fatal("the upstream saw no tools/call")
+	}
+	for _, name := range []string{"Cookie", "X-Internal-Trace"} {
+		if value := call.Get(name); value != "" {
+			t.Errorf("client header %s reached the upstream: %q", name, value)
+		}
+	}
+	if got := call.Get("Mcp-Session-Id"); got != "client-session" {
+		t.Errorf("Mcp-Session-Id = %q, want client-session", got)
+	}
+	checkInjected(t, "proxied tools/call", call)
+
+	probe := loadReadinessWaiter(cfg.target, cfg.transport).Probe
+	if err := probe.Probe(context.Background(), "abc123", "1:2"); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, method := range []string{"initialize", "notifications/initialized", "tools/call"} {
+		checkInjected(t, "probe "+method, seen[method])
+	}
+}
+
+// checkInjected checks that a request to the upstream carries the
+// UPSTREAM_HEADERS values of TestHeaderPolicy and no other credentials.
+func checkInjected(t *testing.T, request string, header http.Header) {
+	t.Helper()
+	if header == nil {
+		t.Errorf("the upstream saw no %s", request)
+		return
+	}
+	if got := header.Values("Authorization"); len(got) != 1 || got[0] != "Bearer upstream-token" {
+		t.Errorf("%s: Authorization = %q, want only the injected token", request, got)
+	}
+	if got := header.Get("X-Team"); got != "design" {
+		t.Errorf("%s: X-Team = %q, want design", request, got)
+	}
+}
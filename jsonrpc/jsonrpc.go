@@ -0,0 +1,256 @@
+// Package jsonrpc models JSON-RPC 2.0 messages as exchanged over MCP Streamable
+// HTTP: requests, notifications, responses and errors, alone or in batches.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the only JSON-RPC version understood by this package.
+const Version = "2.0"
+
+// Standard JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ID is a JSON-RPC request id, which may be a string, a number or null. It keeps
+// the id's original encoding so that responses echo it back exactly.
+type ID struct {
+	raw json.RawMessage
+}
+
+// NullID is the id used in error responses to requests whose id is unknown.
+var NullID = &ID{raw: json.RawMessage("null")}
+
+// StringID returns a string id.
+func StringID(s string) *ID {
+	raw, _ := json.Marshal(s)
+	return &ID{raw: raw}
+}
+
+// NumberID returns a numeric id.
+func NumberID(n int64) *ID {
+	return &ID{raw: json.RawMessage(fmt.Sprintf("%d", n))}
+}
+
+// IsNull reports whether the id is the JSON null value.
+func (id *ID) IsNull() bool {
+	return id == nil || bytes.Equal(id.raw, []byte("null"))
+}
+
+// String returns the id as it appears on the wire, for logging.
+func (id *ID) String() string {
+	if id == nil {
+		return "<none>"
+	}
+	return string(id.raw)
+}
+
+// Key returns a value that can be used to match responses to requests.
+func (id *ID) Key() string {
+	if id == nil {
+		return ""
+	}
+	return string(id.raw)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (id ID) MarshalJSON() ([]byte, error) {
+	if len(id.raw) == 0 {
+		return []byte("null"), nil
+	}
+	return id.raw, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting strings, numbers and null.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return errors.New("jsonrpc: empty id")
+	}
+	switch data[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("jsonrpc: invalid string id: %w", err)
+		}
+	case 'n':
+		if string(data) != "null" {
+			return fmt.Errorf("jsonrpc: invalid id %s", data)
+		}
+	default:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("jsonrpc: id must be a string, number or null, got %s", data)
+		}
+	}
+	id.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// Message is any JSON-RPC message. Which fields are set determines its kind:
+// requests have a Method and an ID, notifications a Method without an ID, and
+// responses an ID with either a Result or an Error.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *ID             `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It keeps an explicit null id, which
+// the default decoding would drop, so that null-id responses are not mistaken
+// for notifications.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	var aux struct {
+		message
+		RawID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*m = Message(aux.message)
+	m.ID = nil
+	if aux.RawID != nil {
+		m.ID = &ID{}
+		if err := m.ID.UnmarshalJSON(aux.RawID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsRequest reports whether the message is a request expecting a response.
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && m.ID != nil
+}
+
+// IsNotification reports whether the message is a notification.
+func (m *Message) IsNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+// IsResponse reports whether the message is a result or error response.
+func (m *Message) IsResponse() bool {
+	return m.Method == "" && (m.Result != nil || m.Error != nil)
+}
+
+// Validate checks the message against the JSON-RPC 2.0 structure.
+func (m *Message) Validate() error {
+	if m.JSONRPC != Version {
+		return fmt.Errorf("jsonrpc: unsupported version %q", m.JSONRPC)
+	}
+	switch {
+	case m.Method != "":
+		if m.Result != nil || m.Error != nil {
+			return errors.New("jsonrpc: request must not carry a result or error")
+		}
+	case m.Result != nil && m.Error != nil:
+		return errors.New("jsonrpc: response must not carry both a result and an error")
+	case m.Result == nil && m.Error == nil:
+		return errors.New("jsonrpc: message is neither a request nor a response")
+	case m.ID == nil:
+		return errors.New("jsonrpc: response is missing its id")
+	}
+	return nil
+}
+
+// NewResponse returns a result response to the request with the given id.
+func NewResponse(id *ID, result interface{}) (*Message, error) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{JSONRPC: Version, ID: id, Result: raw}, nil
+}
+
+// NewErrorResponse returns an error response to the request with the given id.
+// A nil id is sent as null.
+func NewErrorResponse(id *ID, code int, message string, data interface{}) *Message {
+	if id == nil {
+		id = NullID
+	}
+	rpcErr := &Error{Code: code, Message: message}
+	if data != nil {
+		if raw, err := json.Marshal(data); err == nil {
+			rpcErr.Data = raw
+		}
+	}
+	return &Message{JSONRPC: Version, ID: id, Error: rpcErr}
+}
+
+// Payload is a decoded HTTP body: a single message or a batch of messages.
+type Payload struct {
+	Messages []*Message
+	Batch    bool
+}
+
+// Parse decodes a single JSON-RPC message or a batch array. Every message is
+// validated.
+func Parse(data []byte) (*Payload, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("jsonrpc: empty body")
+	}
+
+	if data[0] == '[' {
+		var msgs []*Message
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			return nil, fmt.Errorf("jsonrpc: invalid batch: %w", err)
+		}
+		if len(msgs) == 0 {
+			return nil, errors.New("jsonrpc: empty batch")
+		}
+		for i, msg := range msgs {
+			if msg == nil {
+				return nil, fmt.Errorf("jsonrpc: batch entry %d is null", i)
+			}
+			if err := msg.Validate(); err != nil {
+				return nil, fmt.Errorf("batch entry %d: %w", i, err)
+			}
+		}
+		return &Payload{Messages: msgs, Batch: true}, nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("jsonrpc: invalid message: %w", err)
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Payload{Messages: []*Message{&msg}}, nil
+}
+
+// Marshal encodes the payload, as an array when it is a batch.
+func (p *Payload) Marshal() ([]byte, error) {
+	if p.Batch {
+		return json.Marshal(p.Messages)
+	}
+	if len(p.Messages) != 1 {
+		return nil, fmt.Errorf("jsonrpc: non-batch payload with %d messages", len(p.Messages))
+	}
+	return json.Marshal(p.Messages[0])
+}
@@ -0,0 +1,218 @@
+package jsonrpc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		null bool
+	}{
+		{name: "string", id: `"req-1"`},
+		{name: "escaped string", id: `"café \"1\""`},
+		{name: "empty string", id: `""`},
+		{name: "integer", id: `42`},
+		{name: "negative", id: `-7`},
+		{name: "fraction", id: `1.50`},
+		{name: "exponent", id: `1e3`},
+		{name: "large number", id: `123456789012345678901234567890`},
+		{name: "null", id: `null`, null: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"jsonrpc":"2.0","id":` + tt.id + `,"result":{}}`
+			payload, err := Parse([]byte(body))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			msg := payload.Messages[0]
+			if msg.ID == nil {
+				t.Fatal("id was dropped")
+			}
+			if got := msg.ID.IsNull(); got != tt.null {
+				t.Errorf("IsNull = %v, want %v", got, tt.null)
+			}
+			if got := msg.ID.Key(); got != tt.id {
+				t.Errorf("Key = %s, want %s", got, tt.id)
+			}
+			raw, err := msg.ID.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != tt.id {
+				t.Errorf("MarshalJSON = %s, want %s", raw, tt.id)
+			}
+			out, err := payload.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != body {
+				t.Errorf("Marshal = %s, want %s", out, body)
+			}
+		})
+	}
+}
+
+func TestIDRejectsOtherTypes(t *testing.T) {
+	for _, id := range []string{`true`, `{}`, `[1]`, `nul`, `"open`} {
+		body := `{"jsonrpc":"2.0","id":` + id + `,"method":"ping"}`
+		if _, err := Parse([]byte(body)); err == nil {
+			t.Errorf("Parse accepted id %s", id)
+		}
+	}
+}
+
+func TestIDConstructors(t *testing.T) {
+	if got := StringID(`a"b`).Key(); got != `"a\"b"` {
+		t.Errorf("StringID = %s", got)
+	}
+	if got := NumberID(-3).Key(); got != `-3` {
+		t.Errorf("NumberID = %s", got)
+	}
+	var none *ID
+	if !none.IsNull() || none.String() != "<none>" || none.Key() != "" {
+		t.Errorf("nil id: IsNull %v, String %q, Key %q", none.IsNull(), none.String(), none.Key())
+	}
+	raw, _ := ID{}.MarshalJSON()
+	if string(raw) != "null" {
+		t.Errorf("zero id marshals to %s, want null", raw)
+	}
+}
+
+func TestMessageKinds(t *testing.T) {
+	tests := []struct {
+		name                           string
+		body                           string
+		request, notification, respond bool
+	}{
+		{name: "request", body: `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, request: true},
+		{name: "notification", body: `{"jsonrpc":"2.0","method":"notifications/initialized"}`, notification: true},
+		{name: "result", body: `{"jsonrpc":"2.0","id":"a","result":{"tools":[]}}`, respond: true},
+		{name: "null result", body: `{"jsonrpc":"2.0","id":1,"result":null}`, respond: true},
+		{name: "error", body: `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Method not found"}}`, respond: true},
+		{name: "error with null id", body: `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`, respond: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := Parse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			msg := payload.Messages[0]
+			if msg.IsRequest() != tt.request || msg.IsNotification() != tt.notification || msg.IsResponse() != tt.respond {
+				t.Errorf("IsRequest %v, IsNotification %v, IsResponse %v", msg.IsRequest(), msg.IsNotification(), msg.IsResponse())
+			}
+			out, err := payload.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.body {
+				t.Errorf("Marshal = %s, want %s", out, tt.body)
+			}
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	msg := NewErrorResponse(nil, CodeInvalidParams, "bad", map[string]string{"field": "fileKey"})
+	out, err := (&Payload{Messages: []*Message{msg}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"jsonrpc":"2.0","id":null,"error":{"code":-32602,"message":"bad","data":{"field":"fileKey"}}}`
+	if string(out) != want {
+		t.Errorf("Marshal = %s, want %s", out, want)
+	}
+	if got := msg.Error.Error(); got != "jsonrpc error -32602: bad" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	resp, err := NewResponse(StringID("x"), map[string]int{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = (&Payload{Messages: []*Message{resp}}).Marshal()
+	if want := `{"jsonrpc":"2.0","id":"x","result":{"n":1}}`; string(out) != want {
+		t.Errorf("Marshal = %s, want %s", out, want)
+	}
+}
+
+func TestValidateRejections(t *testing.T) {
+	tests := []struct {
+		name, body, err string
+	}{
+		{name: "missing version", body: `{"id":1,"method":"ping"}`, err: "unsupported version"},
+		{name: "wrong version", body: `{"jsonrpc":"1.0","id":1,"method":"ping"}`, err: "unsupported version"},
+		{name: "request with result", body: `{"jsonrpc":"2.0","id":1,"method":"ping","result":{}}`, err: "must not carry"},
+		{name: "request with error", body: `{"jsonrpc":"2.0","id":1,"method":"ping","error":{"code":1,"message":"x"}}`, err: "must not carry"},
+		{name: "result and error", body: `{"jsonrpc":"2.0","id":1,"result":{},"error":{"code":1,"message":"x"}}`, err: "both a result and an error"},
+		{name: "empty message", body: `{"jsonrpc":"2.0","id":1}`, err: "neither a request nor a response"},
+		{name: "response without id", body: `{"jsonrpc":"2.0","result":{}}`, err: "missing its id"},
+		{name: "not an object", body: `"ping"`, err: "invalid message"},
+		{name: "empty body", body: "  \n", err: "empty body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	body := `[{"jsonrpc":"2.0","id":"a","method":"tools/list"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"x"}}]`
+	payload, err := Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !payload.Batch || len(payload.Messages) != 3 {
+		t.Fatalf("Batch %v with %d messages, want a batch of 3", payload.Batch, len(payload.Messages))
+	}
+	out, err := payload.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != body {
+		t.Errorf("Marshal = %s, want %s", out, body)
+	}
+
+	single, err := Parse([]byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := single.Marshal(); string(out) != `[{"jsonrpc":"2.0","id":1,"method":"ping"}]` {
+		t.Errorf("a batch of one marshals to %s, want an array", out)
+	}
+}
+
+func TestParseBatchRejections(t *testing.T) {
+	tests := []struct {
+		name, body, err string
+	}{
+		{name: "empty", body: `[]`, err: "empty batch"},
+		{name: "null entry", body: `[{"jsonrpc":"2.0","id":1,"method":"ping"},null]`, err: "batch entry 1 is null"},
+		{name: "empty entry", body: `[{}]`, err: "batch entry 0"},
+		{name: "invalid entry", body: `[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","result":{}}]`, err: "batch entry 1"},
+		{name: "not objects", body: `[1,2]`, err: "invalid batch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPayloadMarshalRejectsSeveralMessages(t *testing.T) {
+	payload := &Payload{Messages: []*Message{{JSONRPC: Version}, {JSONRPC: Version}}}
+	if _, err := payload.Marshal(); err == nil {
+		t.Error("a non-batch payload with two messages was marshaled")
+	}
+}
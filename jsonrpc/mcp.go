@@ -0,0 +1,122 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MCP methods the proxy inspects
+const (
+	MethodToolsList = "tools/list"
+	MethodToolsCall = "tools/call"
+)
+
+// ToolCallParams are the params of a tools/call request.
+type ToolCallParams struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// ToolCall decodes the params of a tools/call request. It returns false for any
+// other method.
+func (m *Message) ToolCall() (*ToolCallParams, bool, error) {
+	if m.Method != MethodToolsCall {
+		return nil, false, nil
+	}
+	var params ToolCallParams
+	if len(m.Params) > 0 {
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, true, fmt.Errorf("jsonrpc: invalid tools/call params: %w", err)
+		}
+	}
+	return &params, true, nil
+}
+
+// StringArgument returns a string argument of a tool call.
+func (p *ToolCallParams) StringArgument(name string) (string, bool) {
+	value, ok := p.Arguments[name].(string)
+	return value, ok
+}
+
+// RemoveToolArguments deletes the named arguments from a tools/call request and
+// re-encodes its params, leaving every other field untouched. It returns the
+// names that were actually removed.
+func (m *Message) RemoveToolArguments(names []string) ([]string, error) {
+	var removed []string
+	err := m.editToolArguments(func(arguments map[string]interface{}) bool {
+		for _, name := range names {
+			if _, exists := arguments[name]; exists {
+				delete(arguments, name)
+				removed = append(removed, name)
+			}
+		}
+		return len(removed) > 0
+	})
+	return removed, err
+}
+
+// SetToolArgument sets an argument of a tools/call request and re-encodes its
+// params, leaving every other field untouched.
+func (m *Message) SetToolArgument(name string, value interface{}) error {
+	return m.editToolArguments(func(arguments map[string]interface{}) bool {
+		arguments[name] = value
+		return true
+	})
+}
+
+// SetToolName changes the name of the tool a tools/call request calls and
+// re-encodes its params, leaving every other field untouched.
+func (m *Message) SetToolName(name string) error {
+	return m.editToolParams(func(params map[string]interface{}) (bool, error) {
+		params["name"] = name
+		return true, nil
+	})
+}
+
+// editToolArguments decodes the arguments of a tools/call request, lets edit
+// change them and re-encodes the params when edit reports a change.
+func (m *Message) editToolArguments(edit func(arguments map[string]interface{}) bool) error {
+	return m.editToolParams(func(params map[string]interface{}) (bool, error) {
+		arguments, ok := params["arguments"].(map[string]interface{})
+		if !ok {
+			if _, exists := params["arguments"]; exists && params["arguments"] != nil {
+				return false, fmt.Errorf("jsonrpc: tools/call arguments are not an object")
+			}
+			arguments = map[string]interface{}{}
+		}
+		if !edit(arguments) {
+			return false, nil
+		}
+		params["arguments"] = arguments
+		return true, nil
+	})
+}
+
+// editToolParams decodes the params of a tools/call request, lets edit change
+// them and re-encodes them when edit reports a change.
+func (m *Message) editToolParams(edit func(params map[string]interface{}) (bool, error)) error {
+	if m.Method != MethodToolsCall {
+		return nil
+	}
+
+	params := map[string]interface{}{}
+	if len(m.Params) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(m.Params))
+		// Keep numbers as written, they are re-encoded below
+		decoder.UseNumber()
+		if err := decoder.Decode(&params); err != nil {
+			return fmt.Errorf("jsonrpc: invalid tools/call params: %w", err)
+		}
+	}
+	if changed, err := edit(params); err != nil || !changed {
+		return err
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	m.Params = raw
+	return nil
+}
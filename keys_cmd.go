@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+)
+
+const keysUsage = `Usage: figma-mcp-proxy keys <command> [flags] [name]
+
+Manage the API key store named by API_KEYS_FILE or -file. A running proxy picks
+up changes without a restart.
+
+Commands:
+  create NAME   Create a key and print its secret
+  list          List the keys
+  revoke NAME   Disable a key
+  rotate NAME   Replace the secret of a key and print it
+
+Run "figma-mcp-proxy keys <command> -h" for the flags of a command.
+`
+
+// runKeysCommand runs a "keys" subcommand and returns the process exit code.
+// Secrets are printed to stdout exactly once, everything else goes to stderr.
+func runKeysCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, keysUsage)
+		return 2
+	}
+
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("keys "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	file := flags.String("file", os.Getenv("API_KEYS_FILE"), "key store `path`")
+
+	var err error
+	switch command {
+	case "create":
+		allowTools := flags.String("allow-tools", "", "comma separated tool name `globs` the key may call, all when empty")
+		denyTools := flags.String("deny-tools", "", "comma separated tool name `globs` the key may not call")
+		files := flags.String("files", "", "comma separated file key `globs` the key may use, all when empty")
+		admin := flags.Bool("admin", false, "allow every tool and file")
+		expires := flags.String("expires", "", "expiry as a `duration` such as 720h or an RFC 3339 time, never when empty")
+		name, ok := parseKeysFlags(flags, args)
+		if !ok {
+			return 2
+		}
+		key := &keystore.Key{Name: name, CreatedAt: time.Now().UTC()}
+		key.Scopes.Admin = *admin
+		key.Scopes.Files = splitList(*files)
+		if allow, deny := splitList(*allowTools), splitList(*denyTools); len(allow) > 0 || len(deny) > 0 {
+			key.Scopes.Tools = &keystore.ToolPolicy{Allow: allow, Deny: deny}
+		}
+		if key.ExpiresAt, err = parseExpiry(*expires); err == nil {
+			err = createKey(*file, key, stdout, stderr)
+		}
+	case "list":
+		if _, ok := parseKeysFlags(flags, args); !ok {
+			return 2
+		}
+		err = listKeys(*file, stdout)
+	case "revoke":
+		name, ok := parseKeysFlags(flags, args)
+		if !ok {
+			return 2
+		}
+		err = updateKey(*file, name, func(key *keystore.Key) error {
+			key.Disabled = true
+			return nil
+		})
+		if err == nil {
+			fmt.Fprintf(stderr, "Revoked key %q\n", name)
+		}
+	case "rotate":
+		name, ok := parseKeysFlags(flags, args)
+		if !ok {
+			return 2
+		}
+		var secret string
+		err = updateKey(*file, name, func(key *keystore.Key) error {
+			var err error
+			if secret, err = keystore.NewSecret(); err != nil {
+				return err
+			}
+			return key.SetSecret(secret)
+		})
+		// Only a secret that was saved is printed, like createKey does
+		if err == nil {
+			fmt.Fprintf(stderr, "Rotated key %q, store this secret now, it cannot be shown again:\n", name)
+			fmt.Fprintln(stdout, secret)
+		}
+	default:
+		fmt.Fprintf(stderr, "Unknown keys command %q\n\n%s", command, keysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseKeysFlags parses the flags of a command, which may come before or after
+// the key name, and returns the name. Commands that take a name require one.
+func parseKeysFlags(flags *flag.FlagSet, args []string) (string, bool) {
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return "", false
+	}
+	if name == "" {
+		name = flags.Arg(0)
+	}
+	if flags.Lookup("file").Value.String() == "" {
+		fmt.Fprintln(flags.Output(), "Error: set API_KEYS_FILE or pass -file")
+		return "", false
+	}
+	if name == "" && flags.Name() != "keys list" {
+		fmt.Fprintf(flags.Output(), "Error: %s requires a key name\n", flags.Name())
+		return "", false
+	}
+	return name, true
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseExpiry(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		expires := time.Now().Add(d).UTC()
+		return &expires, nil
+	}
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry %q, expected a duration or an RFC 3339 time", value)
+	}
+	return &expires, nil
+}
+
+// loadStoreFile reads the key store, treating a missing file as an empty store.
+func loadStoreFile(file string) (*keystore.Store, error) {
+	store, err := keystore.Load(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &keystore.Store{}, nil
+	}
+	return store, err
+}
+
+func createKey(file string, key *keystore.Key, stdout, stderr io.Writer) error {
+	store, err := loadStoreFile(file)
+	if err != nil {
+		return err
+	}
+	secret, err := keystore.NewSecret()
+	if err != nil {
+		return err
+	}
+	if err := key.SetSecret(secret); err != nil {
+		return err
+	}
+	if err := store.Add(key); err != nil {
+		return err
+	}
+	if err := store.Save(file); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Created key %q, store this secret now, it cannot be shown again:\n", key.Name)
+	fmt.Fprintln(stdout, secret)
+	return nil
+}
+
+func updateKey(file, name string, update func(key *keystore.Key) error) error {
+	store, err := keystore.Load(file)
+	if err != nil {
+		return err
+	}
+	key, ok := store.Find(name)
+	if !ok {
+		return fmt.Errorf("no key named %q", name)
+	}
+	if err := update(key); err != nil {
+		return err
+	}
+	return store.Save(file)
+}
+
+func listKeys(file string, stdout io.Writer) error {
+	store, err := loadStoreFile(file)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tSCOPES\tCREATED\tEXPIRES")
+	now := time.Now()
+	for _, key := range store.Keys {
+		status := "active"
+		if key.Disabled {
+			status = "revoked"
+		} else if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+			status = "expired"
+		}
+		expires := "never"
+		if key.ExpiresAt != nil {
+			expires = key.ExpiresAt.Format(time.RFC3339)
+		}
+		created := "-"
+		if !key.CreatedAt.IsZero() {
+			created = key.CreatedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.Name, status, describeScopes(key.Scopes), created, expires)
+	}
+	return w.Flush()
+}
+
+func describeScopes(scopes keystore.Scopes) string {
+	if scopes.Admin {
+		return "admin"
+	}
+	var parts []string
+	if scopes.Tools != nil && len(scopes.Tools.Allow) > 0 {
+		parts = append(parts, "tools="+strings.Join(scopes.Tools.Allow, ","))
+	}
+	if scopes.Tools != nil && len(scopes.Tools.Deny) > 0 {
+		parts = append(parts, "deny-tools="+strings.Join(scopes.Tools.Deny, ","))
+	}
+	if len(scopes.Files) > 0 {
+		parts = append(parts, "files="+strings.Join(scopes.Files, ","))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, " ")
+}
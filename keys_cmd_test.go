@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+)
+
+// runKeys runs a keys command against file and returns its exit code, stdout
+// and stderr.
+func runKeys(file string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runKeysCommand(append(args, "-file", file), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func loadTestStore(t *testing.T, file string) *keystore.Store {
+	t.Helper()
+	store, err := keystore.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestKeysCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+
+	code, stdout, stderr := runKeys(file, "create", "ci", "-deny-tools", "get_image", "-files", "abc*,def*", "-expires", "720h")
+	if code != 0 {
+		t.Fatalf("create exited with %d: %s", code, stderr)
+	}
+	secret := strings.TrimSpace(stdout)
+	if strings.Count(stdout, "\n") != 1 || secret == "" {
+		t.Fatalf("create stdout = %q, want only the secret", stdout)
+	}
+	if !strings.Contains(stderr, `Created key "ci"`) {
+		t.Errorf("create stderr = %q", stderr)
+	}
+	key, err := loadTestStore(t, file).Authenticate(secret, time.Now())
+	if err != nil || key.Name != "ci" {
+		t.Fatalf("created secret authenticates as %+v, %v", key, err)
+	}
+	if key.ExpiresAt == nil || key.Scopes.Tools == nil || key.Scopes.Tools.Deny[0] != "get_image" || len(key.Scopes.Files) != 2 {
+		t.Errorf("created key = %+v, want its scopes and expiry", key)
+	}
+
+	if code, _, stderr := runKeys(file, "create", "ci"); code != 1 || !strings.Contains(stderr, "already exists") {
+		t.Errorf("duplicate create = %d %q, want exit 1", code, stderr)
+	}
+	if code, _, _ := runKeys(file, "create", "admin", "-admin"); code != 0 {
+		t.Fatalf("create admin exited with %d", code)
+	}
+
+	code, stdout, _ = runKeys(file, "rotate", "ci")
+	if code != 0 {
+		t.Fatalf("rotate exited with %d", code)
+	}
+	rotated := strings.TrimSpace(stdout)
+	store := loadTestStore(t, file)
+	if _, err := store.Authenticate(secret, time.Now()); err == nil {
+		t.Error("the old secret still authenticates after rotate")
+	}
+	if key, err := store.Authenticate(rotated, time.Now()); err != nil || key.Name != "ci" {
+		t.Errorf("rotated secret authenticates as %+v, %v", key, err)
+	}
+
+	code, stdout, stderr = runKeys(file, "revoke", "ci")
+	if code != 0 || stdout != "" || !strings.Contains(stderr, `Revoked key "ci"`) {
+		t.Fatalf("revoke = %d %q %q", code, stdout, stderr)
+	}
+	if _, err := loadTestStore(t, file).Authenticate(rotated, time.Now()); err == nil {
+		t.Error("a revoked key still authenticates")
+	}
+
+	code, stdout, _ = runKeys(file, "list")
+	if code != 0 {
+		t.Fatalf("list exited with %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") {
+		t.Fatalf("list = %q, want a header and two keys", stdout)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "ci" || fields[1] != "revoked" || fields[2] != "deny-tools=get_image" || fields[3] != "files=abc*,def*" {
+		t.Errorf("list ci = %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "admin" || fields[1] != "active" || fields[2] != "admin" || fields[4] != "never" {
+		t.Errorf("list admin = %q", lines[2])
+	}
+	if strings.Contains(stdout, secret) || strings.Contains(stdout, rotated) {
+		t.Error("list prints secrets")
+	}
+}
+
+func TestKeysCommandUsageErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "no command", code: 2},
+		{name: "unknown command", args: []string{"delete", "ci", "-file", file}, code: 2},
+		{name: "missing name", args: []string{"create", "-file", file}, code: 2},
+		{name: "missing file", args: []string{"list", "-file", ""}, code: 2},
+		{name: "unknown key", args: []string{"rotate", "nobody", "-file", file}, code: 1},
+		{name: "invalid expiry", args: []string{"create", "ci", "-expires", "soon", "-file", file}, code: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runKeysCommand(tt.args, &stdout, &stderr); code != tt.code || stdout.Len() != 0 {
+				t.Errorf("exit code = %d, stdout %q, want %d and no output", code, stdout.String(), tt.code)
+			}
+		})
+	}
+}
+
+// TestKeysCommandSaveFailure checks that rotate and revoke report nothing as
+// done when the key file cannot be saved. A file name close to the length limit
+// is readable, but the temporary file Save writes next to it is not.
+func TestKeysCommandSaveFailure(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, strings.Repeat("k", 250))
+	store := &keystore.Store{}
+	key := &keystore.Key{Name: "ci"}
+	secret, _ := keystore.NewSecret()
+	key.SetSecret(secret)
+	store.Add(key)
+	// Save the store under a short name and move it in place
+	short := filepath.Join(dir, "keys.json")
+	if err := store.Save(short); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(short, file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range []string{"rotate", "revoke"} {
+		t.Run(command, func(t *testing.T) {
+			code, stdout, stderr := runKeys(file, command, "ci")
+			if code != 1 {
+				t.Fatalf("%s exited with %d, want 1: %s", command, code, stderr)
+			}
+			if stdout != "" || strings.Contains(stderr, "Rotated") || strings.Contains(stderr, "Revoked") {
+				t.Errorf("%s reported success although saving failed: stdout %q, stderr %q", command, stdout, stderr)
+			}
+		})
+	}
+	if _, err := loadTestStore(t, file).Authenticate(secret, time.Now()); err != nil {
+		t.Errorf("the key changed although saving failed: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Package keystore holds the API keys clients authenticate with. Keys are named,
+// stored as salted SHA-256 hashes of their secret, and carry the scopes that
+// limit what they may do.
+//
+// A key file is a JSON object of the form
+//
+//	{"keys": [{
+//		"name": "tenant-a",
+//		"salt": "5f0c…", "hash": "9a1e…",
+//		"scopes": {"tools": {"deny": ["get_image"]}, "files": ["AbC123*"]},
+//		"expiresAt": "2027-01-01T00:00:00Z"
+//	}]}
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Authentication errors
+var (
+	ErrUnknownKey  = errors.New("unknown API key")
+	ErrKeyDisabled = errors.New("API key is disabled")
+	ErrKeyExpired  = errors.New("API key has expired")
+)
+
+// Store is a set of keys, usually read from a key file.
+type Store struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Key is a named API key. The secret itself is never stored.
+type Key struct {
+	Name string `json:"name"`
+	// Salt and Hash are hex encoded, Hash is SHA-256(Salt || secret)
+	Salt      string     `json:"salt"`
+	Hash      string     `json:"hash"`
+	Scopes    Scopes     `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Disabled  bool       `json:"disabled,omitempty"`
+}
+
+// Scopes limit what a key may do. The zero Scopes allow every tool and file but
+// no administration.
+type Scopes struct {
+	// Tools restricts the tools the key may list and call
+	Tools *ToolPolicy `json:"tools,omitempty"`
+	// Files are path.Match globs of the file keys the key may use, empty
+	// allows every file
+	Files []string `json:"files,omitempty"`
+	// Admin allows every tool and file, whatever Tools and Files say
+	Admin bool `json:"admin,omitempty"`
+}
+
+// ToolPolicy restricts the tools a key may list and call. Allow and Deny are
+// path.Match globs of tool names, as listed to the client. A tool is allowed
+// when it matches Allow, or Allow is empty, and it does not match Deny.
+type ToolPolicy struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Load reads a key file.
+func Load(name string) (*Store, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var store Store
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := store.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &store, nil
+}
+
+// Save writes the store to a key file, replacing it atomically so that a
+// running proxy never reads a partial file.
+func (s *Store) Save(name string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Find returns the key with the given name.
+func (s *Store) Find(name string) (*Key, bool) {
+	for _, key := range s.Keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Add adds a key, which must have a unique name and a secret.
+func (s *Store) Add(key *Key) error {
+	if key.Name == "" {
+		return fmt.Errorf("key has no name")
+	}
+	if _, exists := s.Find(key.Name); exists {
+		return fmt.Errorf("key %q already exists", key.Name)
+	}
+	if err := key.validate(); err != nil {
+		return fmt.Errorf("key %q: %w", key.Name, err)
+	}
+	s.Keys = append(s.Keys, key)
+	return nil
+}
+
+func (s *Store) validate() error {
+	names := map[string]bool{}
+	for i, key := range s.Keys {
+		if key.Name == "" {
+			return fmt.Errorf("key %d has no name", i+1)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicate key name %q", key.Name)
+		}
+		names[key.Name] = true
+		if err := key.validate(); err != nil {
+			return fmt.Errorf("key %q: %w", key.Name, err)
+		}
+	}
+	return nil
+}
+
+func (k *Key) validate() error {
+	if _, err := hex.DecodeString(k.Salt); err != nil || k.Salt == "" {
+		return fmt.Errorf("invalid salt")
+	}
+	if hash, err := hex.DecodeString(k.Hash); err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("invalid hash")
+	}
+	var patterns []string
+	if k.Scopes.Tools != nil {
+		patterns = append(patterns, k.Scopes.Tools.Allow...)
+		patterns = append(patterns, k.Scopes.Tools.Deny...)
+	}
+	patterns = append(patterns, k.Scopes.Files...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the key whose secret was presented. Every key is
+// compared in constant time so that the time taken reveals nothing about which
+// keys exist. Disabled and expired keys are returned along with an error, so
+// that the caller can log their name.
+func (s *Store) Authenticate(secret string, now time.Time) (*Key, error) {
+	var found *Key
+	for _, key := range s.Keys {
+		if key.matches(secret) && found == nil {
+			found = key
+		}
+	}
+	switch {
+	case found == nil:
+		return nil, ErrUnknownKey
+	case found.Disabled:
+		return found, ErrKeyDisabled
+	case found.ExpiresAt != nil && !now.Before(*found.ExpiresAt):
+		return found, ErrKeyExpired
+	}
+	return found, nil
+}
+
+func (k *Key) matches(secret string) bool {
+	salt, err := hex.DecodeString(k.Salt)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(k.Hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashSecret(salt, secret), want) == 1
+}
+
+// NewSecret returns a new random secret for a key.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// secretPrefix makes leaked secrets easy to recognize and search for
+const secretPrefix = "fmp_"
+
+// SetSecret stores a new salted hash of secret in the key.
+func (k *Key) SetSecret(secret string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	k.Salt = hex.EncodeToString(salt)
+	k.Hash = hex.EncodeToString(hashSecret(salt, secret))
+	return nil
+}
+
+// API keys are long random secrets, so a single round of a fast hash is enough
+// to keep them from being read back out of the key file
+func hashSecret(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// Restricted reports whether the key may not use every tool and file. A nil
+// key, used when authentication is off, is unrestricted.
+func (k *Key) Restricted() bool {
+	if k == nil || k.Scopes.Admin {
+		return false
+	}
+	tools := k.Scopes.Tools
+	return k.RestrictsFiles() || (tools != nil && (len(tools.Allow) > 0 || len(tools.Deny) > 0))
+}
+
+// RestrictsFiles reports whether the key may only use some files, so that tool
+// calls that do not name a file must be rejected.
+func (k *Key) RestrictsFiles() bool {
+	return k != nil && !k.Scopes.Admin && len(k.Scopes.Files) > 0
+}
+
+// AllowsTool reports whether the key may list and call the named tool.
+func (k *Key) AllowsTool(name string) bool {
+	if k == nil || k.Scopes.Admin || k.Scopes.Tools == nil {
+		return true
+	}
+	tools := k.Scopes.Tools
+	if len(tools.Allow) > 0 && !matchesAny(tools.Allow, name) {
+		return false
+	}
+	return !matchesAny(tools.Deny, name)
+}
+
+// AllowsFile reports whether the key may use the file with the given key.
+func (k *Key) AllowsFile(fileKey string) bool {
+	if k == nil || k.Scopes.Admin || len(k.Scopes.Files) == 0 {
+		return true
+	}
+	return matchesAny(k.Scopes.Files, fileKey)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
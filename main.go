@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,25 +11,18 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
 
 	"context"
 
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/oauth"
+	"github.com/bitovi/figma-mcp-proxy/rules"
 	"github.com/bitovi/figma-mcp-proxy/util"
 	"github.com/google/uuid"
 )
 
-type MCPRequestBody struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      int         `json:"id"`
-	Method  string      `json:"method"`
-	Params  interface{} `json:"params"`
-}
-
-var designFileMutex sync.Mutex
-
 type ctxKeyRequestID struct{}
 
 func withRequestID(next http.Handler) http.Handler {
@@ -52,6 +47,10 @@ func getRequestID(r *http.Request) string {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeysCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	log.Printf("[MAIN] Starting Figma MCP Proxy application")
 
 	targetURL := os.Getenv("TARGET_URL")
@@ -70,10 +69,122 @@ func main() {
 	}
 	log.Printf("[MAIN] Successfully parsed target URL - Scheme: %s, Host: %s", target.Scheme, target.Host)
 
-	log.Printf("[MAIN] Creating reverse proxy to target: %s", target.String())
-	proxy := httputil.NewSingleHostReverseProxy(target)
+	cfg := &proxyConfig{
+		target:    target,
+		transport: &headerPolicyTransport{policy: loadHeaderPolicy(), next: http.DefaultTransport},
+	}
+	cfg.opener = loadOpener()
+	cfg.nodeChangePolicy = loadNodeChangePolicy()
+	cfg.waiter = loadReadinessWaiter(target, cfg.transport)
+	cfg.sessionTTL = durationFromEnv("SESSION_TTL", 24*time.Hour)
+	cfg.openFailurePolicy = loadOpenFailurePolicy()
+	cfg.lockWriteTimeout = durationFromEnv("LOCK_WRITE_TIMEOUT", defaultLockWriteTimeout)
+	cfg.rules = loadRewriteRules()
+	cfg.stripArguments = loadStripArguments()
+	cfg.auth = &authenticator{keys: loadKeyStore(), oauth: loadOAuth()}
+	cfg.maxBodySize = loadMaxBodySize()
+	log.Printf("[MAIN] Maximum request body size: %d bytes", cfg.maxBodySize)
+	cfg.compressResponses = os.Getenv("COMPRESS_RESPONSES") == "true"
+	log.Printf("[MAIN] Compress responses for clients that accept gzip: %v", cfg.compressResponses)
+
+	http.Handle("/mcp", newMCPHandler(cfg))
+
+	if cfg.auth.oauth != nil {
+		// Clients look for the metadata both at the root and below the path of
+		// the resource
+		http.HandleFunc(oauth.WellKnownPath, cfg.auth.oauth.serveMetadata)
+		http.HandleFunc(oauth.WellKnownPath+"/", cfg.auth.oauth.serveMetadata)
+	}
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[HEALTH] Health check requested from %s", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		resp := struct {
+			Status    string `json:"status"`
+			TargetURL string `json:"targetURL"`
+		}{
+			Status:    "OK",
+			TargetURL: targetURL,
+		}
+		log.Printf("[HEALTH] Responding with status OK, target URL: %s", targetURL)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("[HEALTH] ERROR: Failed to encode JSON response: %v", err)
+			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+		} else {
+			log.Printf("[HEALTH] Health check completed successfully")
+		}
+	})
+
+	port := os.Getenv("PORT")
+	log.Printf("[MAIN] Environment variable PORT: %q", port)
+	if port == "" {
+		port = "3846"
+		log.Printf("[MAIN] No PORT specified, using default: %s", port)
+	} else {
+		log.Printf("[MAIN] Using PORT from environment: %s", port)
+	}
+
+	log.Printf("[MAIN] Starting server on port %s", port)
+	log.Printf("[MAIN] Proxying /mcp requests to: %s", targetURL)
+
+	// The /mcp handler clears its write deadline, the WriteTimeout applies to
+	// the other routes
+	server := &http.Server{
+		Addr:         ":" + port,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	log.Printf("[MAIN] Server configured with timeouts - Read: %v, Write: %v, Idle: %v",
+		server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+
+	log.Printf("[MAIN] Server starting to listen and serve on address: %s", server.Addr)
+	log.Fatal(server.ListenAndServe())
+}
+
+// proxyConfig is the configuration of the /mcp handler, read from the
+// environment by main.
+type proxyConfig struct {
+	target            *url.URL
+	transport         http.RoundTripper
+	opener            util.Opener
+	nodeChangePolicy  NodeChangePolicy
+	waiter            *util.ReadinessWaiter
+	sessionTTL        time.Duration
+	openFailurePolicy OpenFailurePolicy
+	rules             *rules.RuleSet
+	stripArguments    []string
+	auth              *authenticator
+	maxBodySize       int64
+	compressResponses bool
+	// lockWriteTimeout bounds each write to the client while the design file
+	// lock is held
+	lockWriteTimeout time.Duration
+}
+
+// newMCPHandler returns the /mcp handler, which proxies MCP requests to the
+// upstream Figma MCP server.
+func newMCPHandler(cfg *proxyConfig) http.Handler {
+	log.Printf("[MAIN] Creating reverse proxy to target: %s", cfg.target.String())
+	proxy := httputil.NewSingleHostReverseProxy(cfg.target)
+	proxy.Transport = cfg.transport
 	log.Printf("[MAIN] Reverse proxy created successfully")
 
+	switcher := &designSwitcher{
+		opener: cfg.opener,
+		active: newActiveDesign(cfg.nodeChangePolicy),
+		waiter: cfg.waiter,
+	}
+
+	sessions := newSessionDesigns(cfg.sessionTTL)
+	proxyTools := newProxyTools(switcher, sessions)
+
+	openFailurePolicy := cfg.openFailurePolicy
+	lockWriteTimeout := cfg.lockWriteTimeout
+	rewriter := &toolsRewriter{rules: cfg.rules, proxyTools: proxyTools}
+	stripArguments := cfg.stripArguments
+
 	proxyRequestToTarget := proxy.Director
 
 	proxy.Director = func(req *http.Request) {
@@ -100,66 +211,54 @@ func main() {
 			log.Printf("[DIRECTOR] [%s] No external DNS name configured, using default routing", reqID)
 		}
 
-		var rpcReq MCPRequestBody
-		log.Printf("[DIRECTOR] [%s] Checking for request body", reqID)
-		if req.Body != nil {
-			log.Printf("[DIRECTOR] [%s] Request body found, reading...", reqID)
-			requestBody, err := readBody(req.Body)
-			if err != nil {
-				log.Printf("[DIRECTOR] [%s] ERROR: Failed to read request body: %v", reqID, err)
-			} else {
-				log.Printf("[DIRECTOR] [%s] Request body read successfully, length: %d", reqID, len(requestBody))
-				if err := json.Unmarshal([]byte(requestBody), &rpcReq); err != nil {
-					log.Printf("[DIRECTOR] [%s] ERROR: Failed to unmarshal request body: %v", reqID, err)
-				} else {
-					log.Printf("[DIRECTOR] [%s] Request body parsed - Method: %s, ID: %d", reqID, rpcReq.Method, rpcReq.ID)
-					// Check if arguments contains fileKey and fileName to open Figma design
-					log.Printf("[DIRECTOR] [%s] Checking for Figma design parameters in request params", reqID)
-					if params, ok := rpcReq.Params.(map[string]interface{}); ok {
-						log.Printf("[DIRECTOR] [%s] Request params found, checking for arguments", reqID)
-						if arguments, exists := params["arguments"]; exists {
-							log.Printf("[DIRECTOR] [%s] Arguments found in params, checking for Figma parameters", reqID)
-							if argsMap, ok := arguments.(map[string]interface{}); ok {
-								fileKey, fileKeyExists := argsMap["fileKey"].(string)
-								fileName, fileNameExists := argsMap["fileName"].(string)
-								nodeId, nodeIdExists := argsMap["nodeId"].(string)
-								log.Printf("[DIRECTOR] [%s] Figma params check - fileKey: %v, fileName: %v, nodeId: %v", reqID, fileKeyExists, fileNameExists, nodeIdExists)
-
-								if fileKeyExists && fileNameExists && nodeIdExists {
-									log.Printf("[DIRECTOR] [%s] All Figma parameters present, attempting to open design: %s/%s?node-id=%s", reqID, fileKey, fileName, nodeId)
-									designFileMutex.Lock()
-									log.Printf("[DIRECTOR] [%s] Mutex LOCKED for figma://design/%s/%s?node-id=%s", reqID, fileKey, fileName, nodeId)
-									defer func() {
-										designFileMutex.Unlock()
-										log.Printf("[DIRECTOR] [%s] Mutex UNLOCKED for figma://design/%s/%s?node-id=%s", reqID, fileKey, fileName, nodeId)
-									}()
-									if err := util.OpenFigmaDesign(fileKey, fileName, nodeId); err != nil {
-										log.Printf("[DIRECTOR] [%s] ERROR: Failed to open Figma design: %v", reqID, err)
-									} else {
-										log.Printf("[DIRECTOR] [%s] Successfully opened Figma design: figma://design/%s/%s?node-id=%s", reqID, fileKey, fileName, nodeId)
-									}
-								} else {
-									log.Printf("[DIRECTOR] [%s] Missing Figma parameters, skipping design open", reqID)
-								}
-							} else {
-								log.Printf("[DIRECTOR] [%s] Arguments not in expected format", reqID)
-							}
-						} else {
-							log.Printf("[DIRECTOR] [%s] No arguments found in params", reqID)
+		restrictAcceptEncoding(reqID, req)
+
+		log.Printf("[DIRECTOR] [%s] Checking for parsed request", reqID)
+		if rec := requestRecord(req.Context()); rec != nil {
+			log.Printf("[DIRECTOR] [%s] Parsed request - Method: %s, ID: %s", reqID, rec.method, rec.id)
+			// Edit a copy, the record keeps the message as the client sent it
+			forward := *rec.msg
+			msg := &forward
+			// The /mcp handler has already opened the design the tool call targets
+			if rec.bound {
+				design := rec.design
+				// Tools called with only a figmaUrl still need the node it links to
+				if call, _, _ := msg.ToolCall(); call != nil && design.NodeId != "" {
+					if _, exists := call.StringArgument("nodeId"); !exists {
+						log.Printf("[DIRECTOR] [%s] Forwarding nodeId %s derived from figmaUrl", reqID, design.NodeId)
+						if err := msg.SetToolArgument("nodeId", design.NodeId); err != nil {
+							log.Printf("[DIRECTOR] [%s] ERROR: Failed to set nodeId argument: %v", reqID, err)
 						}
-					} else {
-						log.Printf("[DIRECTOR] [%s] Params not in expected format", reqID)
 					}
+				}
+			}
 
-					// Store the original request body before it gets consumed so it can be used to modify the response later
-					log.Printf("[DIRECTOR] [%s] Storing original request body for response modification", reqID)
-					req.Body = io.NopCloser(strings.NewReader(requestBody))
-					req.Header.Set("X-Original-Request-Body", requestBody)
-					log.Printf("[DIRECTOR] [%s] Request body stored in header", reqID)
+			// Tools renamed by the rewrite rules keep their name upstream
+			if rec.toolName != "" {
+				if upstream, ok := rewriter.rules.UpstreamName(rec.toolName); ok {
+					log.Printf("[DIRECTOR] [%s] Calling renamed tool %s as %s upstream", reqID, rec.toolName, upstream)
+					if err := msg.SetToolName(upstream); err != nil {
+						log.Printf("[DIRECTOR] [%s] ERROR: Failed to rename tool call: %v", reqID, err)
+					}
+				}
+			}
+
+			// The upstream does not know the proxy-only arguments and may reject them
+			if removed, err := msg.RemoveToolArguments(stripArguments); err != nil {
+				log.Printf("[DIRECTOR] [%s] ERROR: Failed to strip proxy arguments: %v", reqID, err)
+			} else if len(removed) > 0 || !bytes.Equal(msg.Params, rec.msg.Params) {
+				payload := &jsonrpc.Payload{Messages: []*jsonrpc.Message{msg}}
+				if forwardBody, err := payload.Marshal(); err != nil {
+					log.Printf("[DIRECTOR] [%s] ERROR: Failed to marshal stripped request body: %v", reqID, err)
+				} else {
+					log.Printf("[DIRECTOR] [%s] Rewrote tool call, stripped proxy arguments %v, forwarding body length %d -> %d", reqID, removed, req.ContentLength, len(forwardBody))
+					req.Body = io.NopCloser(bytes.NewReader(forwardBody))
+					req.ContentLength = int64(len(forwardBody))
+					req.Header.Set("Content-Length", strconv.Itoa(len(forwardBody)))
 				}
 			}
 		} else {
-			log.Printf("[DIRECTOR] [%s] No request body present", reqID)
+			log.Printf("[DIRECTOR] [%s] No parsed request, forwarding body unchanged", reqID)
 		}
 
 		log.Printf("[DIRECTOR] [%s] Calling target proxy for %s %s", reqID, req.Method, req.URL.String())
@@ -171,127 +270,35 @@ func main() {
 		reqID := getRequestID(resp.Request)
 		log.Printf("[MODIFY_RESPONSE] [%s] Processing response for %s %s (Status: %d)", reqID, resp.Request.Method, resp.Request.URL.String(), resp.StatusCode)
 
-		// Get the original request body that was stored in the Director function
-		requestBody := resp.Request.Header.Get("X-Original-Request-Body")
-		log.Printf("[MODIFY_RESPONSE] [%s] Retrieved original request body, length: %d", reqID, len(requestBody))
-
-		var rpcReq MCPRequestBody
-		if requestBody != "" {
-			log.Printf("[MODIFY_RESPONSE] [%s] Unmarshaling request body for method detection", reqID)
-			if err := json.Unmarshal([]byte(requestBody), &rpcReq); err != nil {
-				log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to unmarshal request body: %v", reqID, err)
-			} else {
-				log.Printf("[MODIFY_RESPONSE] [%s] Request body unmarshaled - Method: %s", reqID, rpcReq.Method)
+		// The request parsed by the /mcp handler tells whether this response is rewritten
+		ids := map[string]bool{}
+		rw := rewriter.withKey(requestKey(resp.Request.Context()))
+		if rec := requestRecord(resp.Request.Context()); rec != nil {
+			log.Printf("[MODIFY_RESPONSE] [%s] Parsed request - Method: %s, ID: %s", reqID, rec.method, rec.id)
+			if rec.isToolsList() {
+				ids[rec.id.Key()] = true
+			} else if rec.openWarning != "" && rec.msg.IsRequest() {
+				log.Printf("[MODIFY_RESPONSE] [%s] Design was not opened, adding a warning to the tool result", reqID)
+				ids[rec.id.Key()] = true
+				rw = rw.withWarning(rec.openWarning)
 			}
 		} else {
-			log.Printf("[MODIFY_RESPONSE] [%s] No request body to process", reqID)
+			log.Printf("[MODIFY_RESPONSE] [%s] No parsed request to process", reqID)
 		}
 
-		if rpcReq.Method == "tools/list" {
-			log.Printf("[MODIFY_RESPONSE] [%s] Processing tools/list response for modification", reqID)
+		if len(ids) > 0 {
+			log.Printf("[MODIFY_RESPONSE] [%s] Processing response for modification", reqID)
 			// modify the response so that any tool call that has nodeId in the inputSchema.properties also takes a fileKey and fileName property
-			if resp.StatusCode == http.StatusOK {
-				log.Printf("[MODIFY_RESPONSE] [%s] Response status OK, reading response body", reqID)
-				// Read the entire response body as text
-				rawBody, err := io.ReadAll(resp.Body)
-				if err != nil {
-					log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to read response body: %v", reqID, err)
-					return err
-				}
-				log.Printf("[MODIFY_RESPONSE] [%s] Response body read, length: %d", reqID, len(rawBody))
-
-				// Try to extract JSON from SSE format (lines starting with "data: ")
-				log.Printf("[MODIFY_RESPONSE] [%s] Extracting JSON payload from SSE format", reqID)
-				var jsonPayload string
-				for _, line := range strings.Split(string(rawBody), "\n") {
-					line = strings.TrimSpace(line)
-					if strings.HasPrefix(line, "data: ") {
-						jsonPayload = strings.TrimPrefix(line, "data: ")
-						log.Printf("[MODIFY_RESPONSE] [%s] Found JSON payload in SSE format, length: %d", reqID, len(jsonPayload))
-						break
-					}
-				}
-				if jsonPayload == "" {
-					log.Printf("[MODIFY_RESPONSE] [%s] No JSON payload found in response, skipping modification", reqID)
-					return nil
-				}
-
-				var responseBody map[string]interface{}
-				if err := json.Unmarshal([]byte(jsonPayload), &responseBody); err != nil {
-					log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to decode JSON payload: %v", reqID, err)
-					return err
-				}
-				log.Printf("[MODIFY_RESPONSE] [%s] JSON payload decoded successfully", reqID)
-
-				if result, ok := responseBody["result"].(map[string]interface{}); ok {
-					log.Printf("[MODIFY_RESPONSE] [%s] Found result object in response", reqID)
-					if tools, ok := result["tools"].([]interface{}); ok {
-						log.Printf("[MODIFY_RESPONSE] [%s] Found %d tools in response", reqID, len(tools))
-						toolsModified := 0
-						for _, tool := range tools {
-							if toolMap, ok := tool.(map[string]interface{}); ok {
-								if inputSchema, exists := toolMap["inputSchema"]; exists {
-									if inputSchemaMap, ok := inputSchema.(map[string]interface{}); ok {
-										if properties, exists := inputSchemaMap["properties"]; exists {
-											if propertiesMap, ok := properties.(map[string]interface{}); ok {
-												if _, exists := propertiesMap["nodeId"]; exists {
-													log.Printf("[MODIFY_RESPONSE] [%s] Found tool with nodeId property, modifying: %v", reqID, toolMap["name"])
-													// Update the tool description to mention fileKey and fileName
-													if desc, ok := toolMap["description"].(string); ok {
-														toolMap["description"] = desc + " Use the fileKey and fileName parameters to specify a file. If a URL is provided, extract the fileKey and fileName from the URL, for example, if given the URL https://figma.com/design/1234/5678?node-id=1-2, the extracted fileKey would be `1234` and the extracted fileName would be `5678`."
-													}
-
-													// Update the tool properties to include fileKey and fileName
-													propertiesMap["fileKey"] = map[string]interface{}{
-														"type":        "string",
-														"description": "The key of the file, extracted from the URL. For example, in https://figma.com/design/1234/5678?node-id=1-2, the fileKey is `1234`.",
-													}
-
-													propertiesMap["fileName"] = map[string]interface{}{
-														"type":        "string",
-														"description": "The name of the file, extracted from the URL. For example, in https://figma.com/design/1234/5678?node-id=1-2, the fileName is `5678`.",
-													}
-
-													// Add fileKey and fileName to required array
-													if required, exists := inputSchemaMap["required"]; exists {
-														if requiredArray, ok := required.([]interface{}); ok {
-															requiredArray = append(requiredArray, "fileKey", "fileName")
-															inputSchemaMap["required"] = requiredArray
-														}
-													} else {
-														inputSchemaMap["required"] = []string{"fileKey", "fileName"}
-													}
-													toolsModified++
-													log.Printf("[MODIFY_RESPONSE] [%s] Tool modified successfully: %v", reqID, toolMap["name"])
-												}
-											}
-										}
-									}
-								}
-							}
-						}
-						log.Printf("[MODIFY_RESPONSE] [%s] Tool modification completed, %d tools modified", reqID, toolsModified)
-					} else {
-						log.Printf("[MODIFY_RESPONSE] [%s] No tools array found in result", reqID)
-					}
-				} else {
-					log.Printf("[MODIFY_RESPONSE] [%s] No result object found in response", reqID)
-				}
-
-				log.Printf("[MODIFY_RESPONSE] [%s] Marshaling modified response body", reqID)
-				modifiedBody, err := json.Marshal(responseBody)
-				if err != nil {
-					log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to marshal modified response body: %v", reqID, err)
-					return err
-				}
-				log.Printf("[MODIFY_RESPONSE] [%s] Response body marshaled, creating new response", reqID)
-				resp.Body = io.NopCloser(strings.NewReader(fmt.Sprintf("event: message\ndata: %s\n\n", modifiedBody)))
-				log.Printf("[MODIFY_RESPONSE] [%s] Modified response body set", reqID)
-			} else {
+			if resp.StatusCode != http.StatusOK {
 				log.Printf("[MODIFY_RESPONSE] [%s] Response status not OK (%d), skipping modification", reqID, resp.StatusCode)
+			} else if err := decodeResponseBody(reqID, resp); err != nil {
+				log.Printf("[MODIFY_RESPONSE] [%s] Cannot decode response body, skipping modification: %v", reqID, err)
+			} else if err := rw.rewriteResponseBody(reqID, resp, ids); err != nil {
+				log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to rewrite response body: %v", reqID, err)
+				return fmt.Errorf("%w: %v", errRewriteFailed, err)
 			}
 		} else {
-			log.Printf("[MODIFY_RESPONSE] [%s] Not a tools/list request, skipping modification", reqID)
+			log.Printf("[MODIFY_RESPONSE] [%s] No response to rewrite, skipping modification", reqID)
 		}
 
 		log.Printf("[MODIFY_RESPONSE] [%s] ModifyResponse completed", reqID)
@@ -302,110 +309,205 @@ func main() {
 		reqID := getRequestID(r)
 		log.Printf("[ERROR_HANDLER] [%s] Proxy error for %s %s: %v", reqID, r.Method, r.URL.String(), err)
 		log.Printf("[ERROR_HANDLER] [%s] MCP Session ID: %s", reqID, r.Header.Get("Mcp-Session-Id"))
-		http.Error(w, "Proxy error: "+err.Error(), http.StatusBadGateway)
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			log.Printf("[ERROR_HANDLER] [%s] Client went away, not responding", reqID)
+			return
+		}
+		var id *jsonrpc.ID
+		if rec := requestRecord(r.Context()); rec != nil {
+			id = rec.id
+		}
+		status, code, message := proxyErrorResponse(err)
+		writeRPCErrorStatus(w, r, reqID, status, id, code, message)
 	}
 
-	var apiKey = os.Getenv("API_KEY")
-	log.Printf("[MAIN] API key configured: %v", apiKey != "")
-	http.Handle("/mcp", withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// serveMessage handles a single parsed JSON-RPC message: proxy tools are
+	// answered locally, and everything else is pointed at the session's current
+	// design when it names none and forwarded upstream under the design file lock.
+	serveMessage := func(w http.ResponseWriter, r *http.Request, payload *jsonrpc.Payload) {
 		reqID := getRequestID(r)
-		log.Printf("[MCP_HANDLER] [%s] Processing /mcp request", reqID)
+		msg := payload.Messages[0]
+		sessionID := r.Header.Get("Mcp-Session-Id")
 
-		if apiKey != "" {
-			log.Printf("[MCP_HANDLER] [%s] API key authentication required", reqID)
-			authHeader := r.Header.Get("Authorization")
-			expectedAuth := fmt.Sprintf("Bearer %s", apiKey)
-			if authHeader != expectedAuth {
-				log.Printf("[MCP_HANDLER] [%s] Authentication failed - received: %q", reqID, authHeader)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if call, isCall, _ := msg.ToolCall(); isCall {
+			if !requestKey(r.Context()).AllowsTool(call.Name) {
+				log.Printf("[MCP_HANDLER] [%s] Tool %s is not allowed for this API key", reqID, call.Name)
+				writeRPCError(w, r, reqID, msg.ID, codeToolForbidden, fmt.Sprintf("tool %q is not allowed", call.Name))
+				return
+			}
+			if tool, ok := proxyTools.lookup(call.Name); ok {
+				proxyTools.serve(w, r, msg, tool, call)
+				return
+			}
+			// Renamed and removed tools are only callable as they are listed,
+			// so that tool scopes cannot be bypassed with the upstream name
+			if rewriter.rules.Hidden(call.Name) {
+				log.Printf("[MCP_HANDLER] [%s] Tool %s is hidden by the rewrite rules", reqID, call.Name)
+				writeRPCError(w, r, reqID, msg.ID, jsonrpc.CodeMethodNotFound, fmt.Sprintf("tool %q not found", call.Name))
 				return
 			}
-			log.Printf("[MCP_HANDLER] [%s] Authentication successful", reqID)
-		} else {
-			log.Printf("[MCP_HANDLER] [%s] No API key configured, skipping authentication", reqID)
 		}
 
-		if r.Method != "GET" && r.ContentLength > 0 && r.ContentLength < 1024*1024 {
-			log.Printf("[MCP_HANDLER] [%s] Reading request body (Content-Length: %d)", reqID, r.ContentLength)
-			body, err := io.ReadAll(r.Body)
-			if err == nil {
-				log.Printf("[MCP_HANDLER] [%s] Received Request %s %s from %s: %s", reqID, r.Method, r.URL.Path, r.RemoteAddr, string(body))
-				r.Body = io.NopCloser(strings.NewReader(string(body)))
-				log.Printf("[MCP_HANDLER] [%s] Request body restored for proxy", reqID)
-			} else {
-				log.Printf("[MCP_HANDLER] [%s] ERROR: Failed to read request body: %v", reqID, err)
+		changed, err := applySessionDesign(reqID, sessions, sessionID, msg)
+		design, bound, targetErr := designFileTarget(msg)
+		if err == nil {
+			err = targetErr
+		}
+		if err == nil && bound {
+			err = design.Validate()
+		}
+		if err != nil {
+			log.Printf("[MCP_HANDLER] [%s] Rejecting tool call with invalid Figma arguments: %v", reqID, err)
+			writeRPCError(w, r, reqID, msg.ID, jsonrpc.CodeInvalidParams, err.Error())
+			return
+		}
+		if bound && !requestKey(r.Context()).AllowsFile(design.FileKey) {
+			log.Printf("[MCP_HANDLER] [%s] File %s is not allowed for this API key", reqID, design.FileKey)
+			writeRPCError(w, r, reqID, msg.ID, codeFileForbidden, fmt.Sprintf("file %q is not allowed", design.FileKey))
+			return
+		}
+		// A call without a file would run against whatever file is open in
+		// Figma, which need not be one the key may use
+		if _, isCall, _ := msg.ToolCall(); isCall && !bound && requestKey(r.Context()).RestrictsFiles() {
+			log.Printf("[MCP_HANDLER] [%s] Tool call names no file, but this API key is limited to some files", reqID)
+			writeRPCError(w, r, reqID, msg.ID, codeFileForbidden, "this API key is limited to some files, pass figmaUrl or fileKey with every tool call")
+			return
+		}
+		rec := newMCPRequest(msg, design, bound)
+		r = r.WithContext(withMCPRequest(r.Context(), rec))
+		if changed {
+			body, err := payload.Marshal()
+			if err != nil {
+				writeRPCError(w, r, reqID, msg.ID, jsonrpc.CodeInternalError, err.Error())
+				return
 			}
-		} else {
-			log.Printf("[MCP_HANDLER] [%s] Skipping body read - Method: %s, ContentLength: %d", reqID, r.Method, r.ContentLength)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
 		}
 
-		log.Printf("[MCP_HANDLER] [%s] Proxying request to target", reqID)
-		proxy.ServeHTTP(w, r)
-		log.Printf("[MCP_HANDLER] [%s] Request processing completed", reqID)
-	})))
-
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[HEALTH] Health check requested from %s", r.RemoteAddr)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		resp := struct {
-			Status    string `json:"status"`
-			TargetURL string `json:"targetURL"`
-		}{
-			Status:    "OK",
-			TargetURL: targetURL,
+		// Hold the design file lock until proxy.ServeHTTP has copied the
+		// full upstream response, including any SSE stream, to the client
+		unlock, err := lockDesignFile(r.Context(), reqID, payload)
+		if err != nil {
+			log.Printf("[MCP_HANDLER] [%s] Client went away while waiting for the design file lock, not responding", reqID)
+			return
 		}
-		log.Printf("[HEALTH] Responding with status OK, target URL: %s", targetURL)
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			log.Printf("[HEALTH] ERROR: Failed to encode JSON response: %v", err)
-			http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
-		} else {
-			log.Printf("[HEALTH] Health check completed successfully")
+		defer unlock()
+		if bound {
+			// A client that stops reading must not hold the lock forever
+			w = newDeadlineWriter(w, reqID, lockWriteTimeout)
 		}
-	})
 
-	port := os.Getenv("PORT")
-	log.Printf("[MAIN] Environment variable PORT: %q", port)
-	if port == "" {
-		port = "3846"
-		log.Printf("[MAIN] No PORT specified, using default: %s", port)
-	} else {
-		log.Printf("[MAIN] Using PORT from environment: %s", port)
+		if bound {
+			log.Printf("[MCP_HANDLER] [%s] All Figma parameters present, attempting to open design: %s", reqID, design)
+			if err := switcher.ensureOpen(r.Context(), reqID, design); err != nil {
+				message := fmt.Sprintf("failed to open %s in Figma: %v", design.WebURL(), err)
+				// A call forwarded anyway runs against whatever file is open,
+				// which a key limited to some files may not read
+				if openFailurePolicy == OpenFailureStrict || requestKey(r.Context()).RestrictsFiles() {
+					writeRPCError(w, r, reqID, msg.ID, codeDesignOpenFailed, message)
+					return
+				}
+				log.Printf("[MCP_HANDLER] [%s] Forwarding tool call despite the failed open, its result will carry a warning", reqID)
+				rec.openWarning = fmt.Sprintf("Warning: the proxy %s. This result may come from a different file that was open in Figma.", message)
+			} else if sessionID != "" {
+				// Only a design that passed the checks above and is open in
+				// Figma becomes the session's current design
+				log.Printf("[SESSION] [%s] Session %s current design is now %s", reqID, sessionID, design)
+				sessions.set(sessionID, design)
+			}
+		}
+
+		log.Printf("[MCP_HANDLER] [%s] Proxying request to target", reqID)
+		proxy.ServeHTTP(w, r)
 	}
 
-	log.Printf("[MAIN] Starting server on port %s", port)
-	log.Printf("[MAIN] Proxying /mcp requests to: %s", targetURL)
+	auth := cfg.auth
+	maxBodySize := cfg.maxBodySize
+	var mcpHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID := getRequestID(r)
+		log.Printf("[MCP_HANDLER] [%s] Processing /mcp request", reqID)
 
-	server := &http.Server{
-		Addr:         ":" + port,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-	log.Printf("[MAIN] Server configured with timeouts - Read: %v, Write: %v, Idle: %v",
-		server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+		// File-bound tool calls queue for the design file lock and wait for
+		// readiness, and event streams stay open, so the server's WriteTimeout
+		// would cut off their responses
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("[MCP_HANDLER] [%s] ERROR: Failed to clear the write deadline: %v", reqID, err)
+		}
 
-	log.Printf("[MAIN] Server starting to listen and serve on address: %s", server.Addr)
-	log.Fatal(server.ListenAndServe())
-}
+		if auth.enabled() {
+			log.Printf("[MCP_HANDLER] [%s] Authentication required", reqID)
+			key, err := auth.authenticate(r)
+			if err != nil {
+				if key != nil {
+					log.Printf("[MCP_HANDLER] [%s] Authentication failed for key %q: %v", reqID, key.Name, err)
+				} else {
+					log.Printf("[MCP_HANDLER] [%s] Authentication failed: %v", reqID, err)
+				}
+				auth.reject(w, r, err)
+				return
+			}
+			log.Printf("[MCP_HANDLER] [%s] Authentication successful with key %q", reqID, key.Name)
+			r = r.WithContext(withAPIKey(r.Context(), key))
+		} else {
+			log.Printf("[MCP_HANDLER] [%s] No API key or OAuth configured, skipping authentication", reqID)
+		}
 
-func readBody(rc io.ReadCloser) (string, error) {
-	log.Printf("[READ_BODY] Starting to read request body")
-	var body string
-	if rc != nil {
-		log.Printf("[READ_BODY] ReadCloser provided, reading all content")
-		b, err := io.ReadAll(rc)
-		if err == nil {
-			body = string(b)
-			log.Printf("[READ_BODY] Successfully read %d bytes from body", len(body))
-			rc.Close()
-			log.Printf("[READ_BODY] ReadCloser closed")
+		if r.Method == http.MethodDelete {
+			if sessionID := r.Header.Get("Mcp-Session-Id"); sessionID != "" {
+				log.Printf("[MCP_HANDLER] [%s] Session %s terminated, clearing its current design", reqID, sessionID)
+				sessions.clear(sessionID)
+			}
+		}
+
+		if r.Method != http.MethodGet && r.ContentLength != 0 {
+			log.Printf("[MCP_HANDLER] [%s] Reading request body (Content-Length: %d, limit: %d)", reqID, r.ContentLength, maxBodySize)
+			body, err := readRequestBody(w, r, maxBodySize)
+			if errors.Is(err, errBodyTooLarge) {
+				log.Printf("[MCP_HANDLER] [%s] Rejecting request: %v", reqID, err)
+				writeRPCErrorStatus(w, r, reqID, http.StatusRequestEntityTooLarge, nil, codeRequestTooLarge,
+					fmt.Sprintf("request body exceeds the limit of %d bytes", maxBodySize))
+				return
+			}
+			if err != nil {
+				log.Printf("[MCP_HANDLER] [%s] ERROR: Failed to read request body: %v", reqID, err)
+				writeRPCErrorStatus(w, r, reqID, http.StatusBadRequest, nil, jsonrpc.CodeInvalidRequest, "failed to read the request body")
+				return
+			}
+			log.Printf("[MCP_HANDLER] [%s] Received Request %s %s from %s: %s", reqID, r.Method, r.URL.Path, r.RemoteAddr, string(body))
+			// The Director forwards this buffer, the body is never read twice
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			log.Printf("[MCP_HANDLER] [%s] Request body restored for proxy", reqID)
+			if payload, err := jsonrpc.Parse(body); err != nil {
+				log.Printf("[MCP_HANDLER] [%s] Request body is not a valid JSON-RPC payload: %v", reqID, err)
+			} else if payload.Batch {
+				serveBatch(w, r, serveMessage, payload)
+				log.Printf("[MCP_HANDLER] [%s] Batch request processing completed", reqID)
+				return
+			} else {
+				serveMessage(w, r, payload)
+				log.Printf("[MCP_HANDLER] [%s] Request processing completed", reqID)
+				return
+			}
 		} else {
-			log.Printf("[READ_BODY] ERROR: Failed to read from ReadCloser: %v", err)
-			return "", err
+			log.Printf("[MCP_HANDLER] [%s] Skipping body read - Method: %s, ContentLength: %d", reqID, r.Method, r.ContentLength)
 		}
-	} else {
-		log.Printf("[READ_BODY] No ReadCloser provided, returning empty body")
+
+		// Tool calls in a body that could not be parsed would bypass the key's scopes
+		if r.Method == http.MethodPost && r.ContentLength != 0 && requestKey(r.Context()).Restricted() {
+			log.Printf("[MCP_HANDLER] [%s] Rejecting unparsed request body from a restricted key", reqID)
+			writeRPCError(w, r, reqID, nil, jsonrpc.CodeInvalidRequest, "the request body could not be checked against the API key's scopes")
+			return
+		}
+
+		log.Printf("[MCP_HANDLER] [%s] Proxying request to target", reqID)
+		proxy.ServeHTTP(w, r)
+		log.Printf("[MCP_HANDLER] [%s] Request processing completed", reqID)
+	})
+	if cfg.compressResponses {
+		mcpHandler = withCompression(mcpHandler)
 	}
-	return body, nil
+	return withRequestID(mcpHandler)
 }
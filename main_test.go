@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/rules"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// eventLog records what happens across goroutines, in order.
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) snapshot() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+// index returns the position of the first event, or of the last one when last
+// is set, -1 when it is missing.
+func (l *eventLog) index(event string, last bool) int {
+	found := -1
+	for i, e := range l.snapshot() {
+		if e == event {
+			found = i
+			if !last {
+				break
+			}
+		}
+	}
+	return found
+}
+
+// loggingOpener records opens in the event log as "open:<fileKey>".
+type loggingOpener struct {
+	util.RecordingOpener
+	log *eventLog
+}
+
+func (o *loggingOpener) Open(ctx context.Context, design util.Design) error {
+	o.log.add("open:" + design.FileKey)
+	return o.RecordingOpener.Open(ctx, design)
+}
+
+// readyProbe reports every design active immediately.
+type readyProbe struct{}
+
+func (readyProbe) Probe(ctx context.Context, fileKey, nodeId string) error { return nil }
+
+// loggingWriter is a flushing response writer that records every write of the
+// response body in the event log as "write:<name>".
+type loggingWriter struct {
+	name   string
+	log    *eventLog
+	header http.Header
+	status int
+
+	mu   sync.Mutex
+	body strings.Builder
+}
+
+func newLoggingWriter(name string, log *eventLog) *loggingWriter {
+	return &loggingWriter{name: name, log: log, header: http.Header{}}
+}
+
+func (w *loggingWriter) Header() http.Header { return w.header }
+
+func (w *loggingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *loggingWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.mu.Lock()
+	w.body.Write(p)
+	w.mu.Unlock()
+	w.log.add("write:" + w.name)
+	return len(p), nil
+}
+
+func (w *loggingWriter) Flush() {}
+
+func (w *loggingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+// newTestConfig returns a handler configuration for an upstream test server,
+// without authentication and with designs reported ready immediately.
+func newTestConfig(t *testing.T, upstream string, opener util.Opener) *proxyConfig {
+	t.Helper()
+	target, err := url.Parse(upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &proxyConfig{
+		target:            target,
+		transport:         http.DefaultTransport,
+		opener:            opener,
+		nodeChangePolicy:  NodeChangeSkip,
+		waiter:            &util.ReadinessWaiter{Probe: readyProbe{}, Interval: 10 * time.Millisecond, Timeout: time.Second},
+		sessionTTL:        time.Hour,
+		openFailurePolicy: OpenFailureStrict,
+		rules:             rules.Default(),
+		stripArguments:    defaultStripArguments,
+		auth:              &authenticator{},
+		maxBodySize:       defaultMaxBodySize,
+		lockWriteTimeout:  defaultLockWriteTimeout,
+	}
+}
+
+func newToolCallRequest(id int, tool string, arguments map[string]interface{}) *http.Request {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  "tools/call",
+		"params":  map[string]interface{}{"name": tool, "arguments": arguments},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	return req
+}
+
+// rpcResponse is a JSON-RPC response to a tool call.
+type rpcResponse struct {
+	Result *struct {
+		Content           []toolContent   `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	} `json:"result"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// serveRPC sends a request through the handler and decodes its JSON-RPC
+// response.
+func serveRPC(t *testing.T, handler http.Handler, req *http.Request) rpcResponse {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return decodeRPCResponse(t, rw.Body.Bytes())
+}
+
+func decodeRPCResponse(t *testing.T, body []byte) rpcResponse {
+	t.Helper()
+	var resp rpcResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid JSON-RPC response %q: %v", body, err)
+	}
+	if (resp.Result == nil) == (resp.Error == nil) {
+		t.Fatalf("response has neither or both of result and error: %s", body)
+	}
+	return resp
+}
+
+// newJSONUpstream returns an upstream server that answers every request with
+// a text tool result.
+func newJSONUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID json.RawMessage `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"content":[{"type":"text","text":"upstream"}]}}`, msg.ID)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// TestFileBoundCallsAreSerialized checks that a tool call for another file only
+// opens its design once the previous call's event stream has been fully copied
+// to its client.
+func TestFileBoundCallsAreSerialized(t *testing.T) {
+	events := &eventLog{}
+	firstStreaming := make(chan struct{})
+	var once sync.Once
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     int `json:"id"`
+			Params struct {
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Errorf("upstream received invalid JSON: %v", err)
+		}
+		nodeId, _ := msg.Params.Arguments["nodeId"].(string)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{\"progressToken\":%d,\"progress\":1}}\n\n", msg.ID)
+		w.(http.Flusher).Flush()
+		if nodeId == "1:1" {
+			once.Do(func() { close(firstStreaming) })
+			// The second call arrives while this stream is still open
+			time.Sleep(300 * time.Millisecond)
+		}
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%d,\"result\":{\"content\":[{\"type\":\"text\",\"text\":\"node %s\"}]}}\n\n", msg.ID, nodeId)
+	}))
+	defer upstream.Close()
+
+	opener := &loggingOpener{log: events}
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, opener))
+
+	first := newLoggingWriter("first", events)
+	second := newLoggingWriter("second", events)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(first, newToolCallRequest(1, "get_code", map[string]interface{}{
+			"figmaUrl": "https://www.figma.com/design/fileOne/One?node-id=1-1",
+		}))
+	}()
+	go func() {
+		defer wg.Done()
+		select {
+		case <-firstStreaming:
+		case <-time.After(5 * time.Second):
+			t.Error("first call never reached the upstream")
+			return
+		}
+		handler.ServeHTTP(second, newToolCallRequest(2, "get_code", map[string]interface{}{
+			"figmaUrl": "https://www.figma.com/design/fileTwo/Two?node-id=2-2",
+		}))
+	}()
+	wg.Wait()
+
+	if !strings.Contains(first.String(), `"text":"node 1:1"`) {
+		t.Fatalf("first response is missing its result: %s", first.String())
+	}
+	if !strings.Contains(second.String(), `"text":"node 2:2"`) {
+		t.Fatalf("second response is missing its result: %s", second.String())
+	}
+
+	lastFirstWrite := events.index("write:first", true)
+	secondOpen := events.index("open:fileTwo", false)
+	if events.index("open:fileOne", false) < 0 || lastFirstWrite < 0 || secondOpen < 0 {
+		t.Fatalf("missing events: %v", events.snapshot())
+	}
+	if secondOpen < lastFirstWrite {
+		t.Errorf("second design was opened before the first response was fully copied: %v", events.snapshot())
+	}
+}
+
+// TestLockWaitGivesUpWhenClientLeaves checks that a call waiting for the
+// design file lock stops waiting when its client goes away, and never opens
+// its design.
+func TestLockWaitGivesUpWhenClientLeaves(t *testing.T) {
+	upstream := newJSONUpstream(t)
+	opener := &util.RecordingOpener{}
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, opener))
+
+	if err := acquireDesignFileLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer releaseDesignFileLock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := newToolCallRequest(1, "get_code", map[string]interface{}{"fileKey": "abc123", "nodeId": "1:2"})
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call kept waiting for the lock after its client went away")
+	}
+	if designs := opener.Designs(); len(designs) != 0 {
+		t.Errorf("opened %+v for a call whose client went away", designs)
+	}
+}
+
+// stalledWriter is a response writer whose client never reads: writes block
+// until the write deadline passes, or forever when there is none.
+type stalledWriter struct {
+	header http.Header
+
+	mu       sync.Mutex
+	deadline time.Time
+	// writing is closed on the first write, closed ends every write
+	writing chan struct{}
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func newStalledWriter() *stalledWriter {
+	return &stalledWriter{header: http.Header{}, writing: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+
+func (w *stalledWriter) WriteHeader(status int) {}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	w.mu.Lock()
+	deadline := w.deadline
+	w.mu.Unlock()
+	if deadline.IsZero() {
+		<-w.closed
+		return 0, io.ErrClosedPipe
+	}
+	select {
+	case <-time.After(time.Until(deadline)):
+		return 0, os.ErrDeadlineExceeded
+	case <-w.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (w *stalledWriter) Flush() {}
+
+func (w *stalledWriter) SetWriteDeadline(deadline time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deadline = deadline
+	return nil
+}
+
+// TestStalledClientReleasesLock checks that a client that stops reading its
+// response only holds the design file lock until a write times out.
+func TestStalledClientReleasesLock(t *testing.T) {
+	upstream := newJSONUpstream(t)
+	cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+	cfg.lockWriteTimeout = 100 * time.Millisecond
+	handler := newMCPHandler(cfg)
+
+	stalled := newStalledWriter()
+	defer close(stalled.closed)
+	stalledDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(stalled, newToolCallRequest(1, "get_code", map[string]interface{}{"fileKey": "abc123", "nodeId": "1:2"}))
+		close(stalledDone)
+	}()
+
+	select {
+	case <-stalled.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first call never wrote its response")
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, newToolCallRequest(2, "get_code", map[string]interface{}{"fileKey": "def456", "nodeId": "1:2"}))
+		done <- rw
+	}()
+	select {
+	case rw := <-done:
+		if resp := decodeRPCResponse(t, rw.Body.Bytes()); resp.Error != nil {
+			t.Errorf("second call failed: %+v", resp.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled client kept the design file lock")
+	}
+	select {
+	case <-stalledDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled call never returned")
+	}
+}
+
+// TestSlowCallOutlivesWriteTimeout checks that /mcp responses are not cut off
+// by the server's WriteTimeout while a call waits for the upstream.
+func TestSlowCallOutlivesWriteTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"slow"}]}}`)
+	}))
+	defer upstream.Close()
+
+	proxy := httptest.NewUnstartedServer(newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{})))
+	proxy.Config.WriteTimeout = 100 * time.Millisecond
+	proxy.Start()
+	defer proxy.Close()
+
+	req := newToolCallRequest(1, "get_code", map[string]interface{}{"nodeId": "1:2"})
+	resp, err := http.Post(proxy.URL+"/mcp", "application/json", req.Body)
+	if err != nil {
+		t.Fatalf("POST /mcp: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	if !strings.Contains(string(body), `"text":"slow"`) {
+		t.Errorf("response = %d %s, want the upstream result", resp.StatusCode, body)
+	}
+}
+
+// TestFileScopedKeyRequiresAFile checks that a key limited to some files cannot
+// call tools without naming a file, which would run against whatever file is
+// open in Figma.
+func TestFileScopedKeyRequiresAFile(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"upstream"}]}}`)
+	}))
+	defer upstream.Close()
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{}))
+
+	scoped := &keystore.Key{Name: "scoped", Scopes: keystore.Scopes{Files: []string{"abc*"}}}
+	admin := &keystore.Key{Name: "admin", Scopes: keystore.Scopes{Files: []string{"abc*"}, Admin: true}}
+	tests := []struct {
+		name      string
+		key       *keystore.Key
+		session   string
+		arguments map[string]interface{}
+		code      int
+	}{
+		{name: "nodeId only", key: scoped, arguments: map[string]interface{}{"nodeId": "1:2"}, code: codeFileForbidden},
+		{name: "no arguments", key: scoped, arguments: map[string]interface{}{}, code: codeFileForbidden},
+		{name: "file outside the scope", key: scoped, arguments: map[string]interface{}{"fileKey": "def456", "nodeId": "1:2"}, code: codeFileForbidden},
+		{name: "allowed file", key: scoped, session: "session-1", arguments: map[string]interface{}{"fileKey": "abc123", "nodeId": "1:2"}},
+		// The session's current design is the allowed file from the call above
+		{name: "session design", key: scoped, session: "session-1", arguments: map[string]interface{}{"nodeId": "1:2"}},
+		{name: "admin key", key: admin, arguments: map[string]interface{}{"nodeId": "1:2"}},
+		{name: "unrestricted key", key: &keystore.Key{Name: "all"}, arguments: map[string]interface{}{"nodeId": "1:2"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&calls)
+			req := newToolCallRequest(i+1, "get_code", tt.arguments)
+			if tt.session != "" {
+				req.Header.Set("Mcp-Session-Id", tt.session)
+			}
+			resp := serveRPC(t, handler, req.WithContext(withAPIKey(req.Context(), tt.key)))
+
+			forwarded := atomic.LoadInt32(&calls) > before
+			if tt.code != 0 {
+				if resp.Error == nil || resp.Error.Code != tt.code {
+					t.Errorf("response = result %+v, error %+v, want error %d", resp.Result, resp.Error, tt.code)
+				}
+				if forwarded {
+					t.Error("rejected call was forwarded upstream")
+				}
+			} else if resp.Error != nil || !forwarded {
+				t.Errorf("response = error %+v, forwarded %v, want a forwarded call", resp.Error, forwarded)
+			}
+		})
+	}
+}
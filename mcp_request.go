@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// mcpRequest is a single JSON-RPC message parsed by the /mcp handler. It is
+// carried on the request context so that the Director and ModifyResponse use
+// the handler's parsed message instead of parsing the body again.
+type mcpRequest struct {
+	method string
+	id     *jsonrpc.ID
+	// toolName is the tool a tools/call request calls, as the client named it
+	toolName string
+	// design is the Figma file a tool call targets when bound is set
+	design util.Design
+	bound  bool
+	// openWarning is added to the tool result when the design could not be
+	// opened and the call was forwarded anyway
+	openWarning string
+
+	// msg is the message as sent by the client, after the session's current
+	// design has been applied
+	msg *jsonrpc.Message
+}
+
+func newMCPRequest(msg *jsonrpc.Message, design util.Design, bound bool) *mcpRequest {
+	rec := &mcpRequest{method: msg.Method, id: msg.ID, design: design, bound: bound, msg: msg}
+	if call, _, _ := msg.ToolCall(); call != nil {
+		rec.toolName = call.Name
+	}
+	return rec
+}
+
+// isToolsList reports whether the request is a tools/list request whose
+// response needs to be rewritten.
+func (rec *mcpRequest) isToolsList() bool {
+	return rec.msg.IsRequest() && rec.method == jsonrpc.MethodToolsList
+}
+
+type ctxKeyMCPRequest struct{}
+
+func withMCPRequest(ctx context.Context, rec *mcpRequest) context.Context {
+	return context.WithValue(ctx, ctxKeyMCPRequest{}, rec)
+}
+
+// requestRecord returns the parsed request, or nil when the /mcp handler did
+// not parse the request body.
+func requestRecord(ctx context.Context) *mcpRequest {
+	rec, _ := ctx.Value(ctxKeyMCPRequest{}).(*mcpRequest)
+	return rec
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/oauth"
+)
+
+// Scopes of OAuth access tokens that map to the scopes of API keys
+const (
+	oauthAdminScope       = "figma-mcp:admin"
+	oauthToolsScopePrefix = "figma-mcp:tools:"
+	oauthFilesScopePrefix = "figma-mcp:files:"
+)
+
+// oauthResource makes /mcp an OAuth 2.1 protected resource that accepts JWT
+// access tokens.
+type oauthResource struct {
+	validator   *oauth.Validator
+	metadata    oauth.ResourceMetadata
+	metadataURL string
+}
+
+// loadOAuth reads the OAUTH_* environment variables. It returns nil when
+// OAUTH_JWKS is not set, which leaves OAuth off.
+func loadOAuth() *oauthResource {
+	jwks := os.Getenv("OAUTH_JWKS")
+	log.Printf("[MAIN] Environment variable OAUTH_JWKS: %q", jwks)
+	if jwks == "" {
+		return nil
+	}
+
+	resource := os.Getenv("OAUTH_RESOURCE")
+	issuer := os.Getenv("OAUTH_ISSUER")
+	audience := os.Getenv("OAUTH_AUDIENCE")
+	if audience == "" {
+		audience = resource
+	}
+	log.Printf("[MAIN] OAuth resource: %q, issuer: %q, audience: %q", resource, issuer, audience)
+	if resource == "" || issuer == "" {
+		log.Fatalf("[MAIN] OAUTH_RESOURCE and OAUTH_ISSUER are required with OAUTH_JWKS")
+	}
+	metadataURL, err := oauth.MetadataURL(resource)
+	if err != nil {
+		log.Fatalf("[MAIN] Invalid OAUTH_RESOURCE: %v", err)
+	}
+
+	servers := splitList(os.Getenv("OAUTH_AUTHORIZATION_SERVERS"))
+	if len(servers) == 0 {
+		servers = []string{issuer}
+	}
+	requiredScopes := strings.Fields(strings.ReplaceAll(os.Getenv("OAUTH_REQUIRED_SCOPES"), ",", " "))
+	log.Printf("[MAIN] OAuth authorization servers: %v, required scopes: %v", servers, requiredScopes)
+
+	keys := oauth.NewJWKS(jwks)
+	if err := keys.Load(context.Background()); err != nil {
+		log.Fatalf("[MAIN] Failed to load OAuth JWKS: %v", err)
+	}
+	return &oauthResource{
+		validator: &oauth.Validator{
+			Keys:           keys,
+			Issuer:         issuer,
+			Audience:       audience,
+			RequiredScopes: requiredScopes,
+			Leeway:         30 * time.Second,
+		},
+		metadata: oauth.ResourceMetadata{
+			Resource:               resource,
+			AuthorizationServers:   servers,
+			ScopesSupported:        append(append([]string(nil), requiredScopes...), oauthAdminScope),
+			BearerMethodsSupported: []string{"header"},
+			ResourceName:           "Figma MCP Proxy",
+		},
+		metadataURL: metadataURL,
+	}
+}
+
+// authenticate validates an access token and returns a key with the
+// permissions its scopes grant:
+//   - figma-mcp:admin allows every tool and file
+//   - figma-mcp:tools:<glob> allows the matching tools, all tools when absent
+//   - figma-mcp:files:<glob> allows the matching file keys, all files when absent
+func (o *oauthResource) authenticate(ctx context.Context, token string) (*keystore.Key, error) {
+	claims, err := o.validator.Validate(ctx, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Subject
+	if name == "" {
+		name = claims.ClientID
+	}
+	key := &keystore.Key{Name: "oauth:" + name, ExpiresAt: &claims.ExpiresAt}
+	var tools []string
+	for _, scope := range claims.Scopes {
+		switch {
+		case scope == oauthAdminScope:
+			key.Scopes.Admin = true
+		case strings.HasPrefix(scope, oauthToolsScopePrefix):
+			tools = append(tools, strings.TrimPrefix(scope, oauthToolsScopePrefix))
+		case strings.HasPrefix(scope, oauthFilesScopePrefix):
+			key.Scopes.Files = append(key.Scopes.Files, strings.TrimPrefix(scope, oauthFilesScopePrefix))
+		}
+	}
+	if len(tools) > 0 {
+		key.Scopes.Tools = &keystore.ToolPolicy{Allow: tools}
+	}
+	return key, nil
+}
+
+// serveMetadata serves the protected resource metadata document.
+func (o *oauthResource) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[OAUTH] Protected resource metadata requested from %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	if err := json.NewEncoder(w).Encode(o.metadata); err != nil {
+		log.Printf("[OAUTH] ERROR: Failed to write protected resource metadata: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKS is a source of the public keys tokens are signed with, read from a JSON
+// Web Key Set file or URL. Keys are cached and read again when they are older
+// than MaxAge, or when a token names a key that is not known yet, at most once
+// per MinRefresh.
+type JWKS struct {
+	// Location is a file path or an http(s) URL
+	Location   string
+	Client     *http.Client
+	MaxAge     time.Duration
+	MinRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      []*jwk
+	fetched   time.Time
+	attempted time.Time
+	err       error
+}
+
+// jwk is a public key of a key set.
+type jwk struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// NewJWKS returns a key source for a JWKS file path or URL.
+func NewJWKS(location string) *JWKS {
+	return &JWKS{
+		Location:   location,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		MaxAge:     10 * time.Minute,
+		MinRefresh: 30 * time.Second,
+	}
+}
+
+// Load reads the key set, reporting any error up front.
+func (j *JWKS) Load(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.attempted = time.Now()
+	j.err = j.refresh(ctx)
+	return j.err
+}
+
+// lookup returns the keys a token with the given kid may be signed with.
+func (j *JWKS) lookup(ctx context.Context, kid string) ([]*jwk, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.keys == nil || time.Since(j.fetched) > j.MaxAge {
+		j.tryRefresh(ctx)
+	}
+	keys := j.match(kid)
+	if len(keys) == 0 && kid != "" {
+		// The key set may have been rotated
+		j.tryRefresh(ctx)
+		keys = j.match(kid)
+	}
+	if j.keys == nil {
+		return nil, j.err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key with kid %q", kid)
+	}
+	return keys, nil
+}
+
+// tryRefresh reads the key set again unless that was tried within MinRefresh.
+// Stale keys are kept when it fails.
+func (j *JWKS) tryRefresh(ctx context.Context) {
+	if time.Since(j.attempted) < j.MinRefresh {
+		return
+	}
+	j.attempted = time.Now()
+	j.err = j.refresh(ctx)
+}
+
+func (j *JWKS) match(kid string) []*jwk {
+	if kid == "" {
+		return j.keys
+	}
+	var keys []*jwk
+	for _, key := range j.keys {
+		if key.kid == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	data, err := j.read(ctx)
+	if err != nil {
+		return fmt.Errorf("read JWKS %s: %w", j.Location, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("parse JWKS %s: %w", j.Location, err)
+	}
+	j.keys, j.fetched = keys, time.Now()
+	return nil
+}
+
+func (j *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.Location, "https://") && !strings.HasPrefix(j.Location, "http://") {
+		return os.ReadFile(j.Location)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.Location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWKS decodes the RSA and EC signing keys of a key set. Other keys, such
+// as encryption keys, are skipped.
+func parseJWKS(data []byte) ([]*jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []*jwk
+	for _, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch raw.Kty {
+		case "RSA":
+			n, errN := decodeBigInt(raw.N)
+			e, errE := decodeBigInt(raw.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %q", raw.Kid)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch raw.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := decodeBigInt(raw.X)
+			y, errY := decodeBigInt(raw.Y)
+			if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %q", raw.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		default:
+			continue
+		}
+		keys = append(keys, &jwk{kid: raw.Kid, alg: raw.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,265 @@
+// Package oauth validates the OAuth 2.1 access tokens MCP clients send to a
+// protected resource: JWTs signed with a key of a JSON Web Key Set and issued
+// for this resource by a trusted authorization server.
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Validation errors. ErrInsufficientScope is answered with 403 Forbidden, any
+// other error with 401 Unauthorized.
+var (
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// Claims are the validated claims of an access token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	// Scopes are read from the space separated scope claim, or the scp array
+	Scopes []string
+	// ClientID is the client_id or azp claim
+	ClientID string
+}
+
+// HasScope reports whether the token was granted a scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Validator checks access tokens.
+type Validator struct {
+	Keys *JWKS
+	// Issuer is the required iss claim
+	Issuer string
+	// Audience is the required aud claim, normally the resource URL
+	Audience string
+	// RequiredScopes must all be granted to the token
+	RequiredScopes []string
+	// Leeway allows for clock skew in exp and nbf
+	Leeway time.Duration
+}
+
+// LooksLikeJWT reports whether a bearer token has the three parts of a JWT, to
+// tell access tokens apart from static API keys.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Validate verifies the signature and claims of a JWT access token.
+func (v *Validator) Validate(ctx context.Context, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	keys, err := v.Keys.lookup(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.alg != "" && key.alg != header.Alg {
+			continue
+		}
+		if err := verify(header.Alg, key.key, signed, signature); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: signature verification failed for alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	var raw struct {
+		Iss      string          `json:"iss"`
+		Sub      string          `json:"sub"`
+		Aud      json.RawMessage `json:"aud"`
+		Exp      *json.Number    `json:"exp"`
+		Nbf      *json.Number    `json:"nbf"`
+		Scope    string          `json:"scope"`
+		Scp      []string        `json:"scp"`
+		ClientID string          `json:"client_id"`
+		Azp      string          `json:"azp"`
+	}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+
+	claims := &Claims{Issuer: raw.Iss, Subject: raw.Sub, ClientID: raw.ClientID, Scopes: raw.Scp}
+	if claims.ClientID == "" {
+		claims.ClientID = raw.Azp
+	}
+	if raw.Scope != "" {
+		claims.Scopes = strings.Fields(raw.Scope)
+	}
+	if claims.Audience, err = decodeAudience(raw.Aud); err != nil {
+		return nil, fmt.Errorf("%w: aud: %v", ErrInvalidToken, err)
+	}
+
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q is not %q", ErrInvalidToken, claims.Issuer, v.Issuer)
+	}
+	if v.Audience != "" && !contains(claims.Audience, v.Audience) {
+		return nil, fmt.Errorf("%w: audience %q does not include %q", ErrInvalidToken, claims.Audience, v.Audience)
+	}
+	if raw.Exp == nil {
+		return nil, fmt.Errorf("%w: no exp claim", ErrInvalidToken)
+	}
+	exp, err := numericDate(*raw.Exp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: exp: %v", ErrInvalidToken, err)
+	}
+	claims.ExpiresAt = exp
+	if !now.Before(exp.Add(v.Leeway)) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrInvalidToken, exp.Format(time.RFC3339))
+	}
+	if raw.Nbf != nil {
+		nbf, err := numericDate(*raw.Nbf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: nbf: %v", ErrInvalidToken, err)
+		}
+		if now.Add(v.Leeway).Before(nbf) {
+			return nil, fmt.Errorf("%w: not valid before %s", ErrInvalidToken, nbf.Format(time.RFC3339))
+		}
+	}
+	for _, scope := range v.RequiredScopes {
+		if !claims.HasScope(scope) {
+			return claims, fmt.Errorf("%w: missing scope %q", ErrInsufficientScope, scope)
+		}
+	}
+	return claims, nil
+}
+
+// verify checks a JWS signature. Only asymmetric algorithms are accepted, so
+// that a public key can never be used as an HMAC secret and "none" is refused.
+func verify(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	digest := digest(hash, signed)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if ecdsa.Verify(k, digest, r, s) {
+			return nil
+		}
+		return fmt.Errorf("invalid signature")
+	}
+	return fmt.Errorf("alg %q does not match the key type", alg)
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// decodeAudience reads an aud claim, which is a string or an array of strings.
+func decodeAudience(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func numericDate(n json.Number) (time.Time, error) {
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,308 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://auth.example.com"
+	testAudience = "https://proxy.example.com/mcp"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+// testKey is a signing key and its JWK.
+type testKey struct {
+	kid     string
+	alg     string
+	private crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, alg: "RS256", private: private}
+}
+
+func newECKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, alg: "ES256", private: private}
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func (k *testKey) jwk() map[string]interface{} {
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]interface{}{"kty": "RSA", "kid": k.kid, "alg": k.alg, "use": "sig",
+			"n": encodeBigInt(public.N), "e": encodeBigInt(big.NewInt(int64(public.E)))}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return map[string]interface{}{"kty": "EC", "kid": k.kid, "alg": k.alg, "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size))),
+			"y": base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))}
+	}
+	panic("unsupported key")
+}
+
+// writeJWKS writes a key set file of the keys, replacing any previous one.
+func writeJWKS(t *testing.T, file string, keys ...*testKey) {
+	t.Helper()
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	data, _ := json.Marshal(set)
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeSegment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a JWT of the claims signed with the key under its alg and kid.
+func (k *testKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	signed := encodeSegment(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns the claims of a token the test validator accepts.
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   testIssuer,
+		"sub":   "user-1",
+		"aud":   testAudience,
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"scope": "mcp figma-mcp:files:abc*",
+	}
+}
+
+func withClaims(changes map[string]interface{}) map[string]interface{} {
+	claims := validClaims()
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+func newTestValidator(t *testing.T, keys ...*testKey) (*Validator, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, keys...)
+	jwks := NewJWKS(file)
+	jwks.MinRefresh = 0
+	if err := jwks.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &Validator{
+		Keys:           jwks,
+		Issuer:         testIssuer,
+		Audience:       testAudience,
+		RequiredScopes: []string{"mcp"},
+		Leeway:         30 * time.Second,
+	}, file
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	ecKey := newECKey(t, "ec-1")
+	validator, _ := newTestValidator(t, rsaKey, ecKey)
+
+	tamper := func(token string) string {
+		parts := strings.Split(token, ".")
+		parts[1] = encodeSegment(withClaims(map[string]interface{}{"sub": "admin"}))
+		return strings.Join(parts, ".")
+	}
+	unsigned := func(alg string) string {
+		return encodeSegment(map[string]string{"alg": alg, "kid": "rsa-1"}) + "." + encodeSegment(validClaims()) + "."
+	}
+	// HS256 with the RSA public key as the HMAC secret, the classic algorithm
+	// confusion attack
+	hs256 := func() string {
+		signed := encodeSegment(map[string]string{"alg": "HS256", "kid": "rsa-1"}) + "." + encodeSegment(validClaims())
+		mac := hmac.New(sha256.New, rsaKey.private.Public().(*rsa.PublicKey).N.Bytes())
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	otherKid := &testKey{kid: "unknown", alg: "RS256", private: rsaKey.private}
+	// A key the key set does not have, published under a known kid
+	impostor := newRSAKey(t, "rsa-1")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{name: "RS256", token: rsaKey.sign(t, validClaims())},
+		{name: "ES256", token: ecKey.sign(t, validClaims())},
+		{name: "audience array", token: rsaKey.sign(t, withClaims(map[string]interface{}{"aud": []string{"other", testAudience}}))},
+		{name: "scp array", token: rsaKey.sign(t, withClaims(map[string]interface{}{"scope": nil, "scp": []string{"mcp"}}))},
+		{name: "bad signature", token: tamper(rsaKey.sign(t, validClaims())), want: ErrInvalidToken},
+		{name: "signed by another key", token: impostor.sign(t, validClaims()), want: ErrInvalidToken},
+		{name: "alg none", token: unsigned("none"), want: ErrInvalidToken},
+		{name: "HS256 with the public key", token: hs256(), want: ErrInvalidToken},
+		{name: "unknown kid", token: otherKid.sign(t, validClaims()), want: ErrInvalidToken},
+		{name: "not a JWT", token: "abc.def", want: ErrInvalidToken},
+		{name: "wrong issuer", token: rsaKey.sign(t, withClaims(map[string]interface{}{"iss": "https://evil.example.com"})), want: ErrInvalidToken},
+		{name: "wrong audience", token: rsaKey.sign(t, withClaims(map[string]interface{}{"aud": "https://other.example.com/mcp"})), want: ErrInvalidToken},
+		{name: "no audience", token: rsaKey.sign(t, withClaims(map[string]interface{}{"aud": nil})), want: ErrInvalidToken},
+		{name: "no exp", token: rsaKey.sign(t, withClaims(map[string]interface{}{"exp": nil})), want: ErrInvalidToken},
+		{name: "expired", token: rsaKey.sign(t, withClaims(map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()})), want: ErrInvalidToken},
+		{name: "expired within leeway", token: rsaKey.sign(t, withClaims(map[string]interface{}{"exp": testNow.Add(-10 * time.Second).Unix()}))},
+		{name: "not yet valid", token: rsaKey.sign(t, withClaims(map[string]interface{}{"nbf": testNow.Add(time.Minute).Unix()})), want: ErrInvalidToken},
+		{name: "not yet valid within leeway", token: rsaKey.sign(t, withClaims(map[string]interface{}{"nbf": testNow.Add(10 * time.Second).Unix()}))},
+		{name: "missing required scope", token: rsaKey.sign(t, withClaims(map[string]interface{}{"scope": "figma-mcp:admin"})), want: ErrInsufficientScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := validator.Validate(context.Background(), tt.token, testNow)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if claims.Subject != "user-1" || claims.Issuer != testIssuer || !claims.HasScope("mcp") {
+					t.Errorf("Validate() claims = %+v", claims)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == ErrInvalidToken && errors.Is(err, ErrInsufficientScope) {
+				t.Errorf("Validate() error = %v, an invalid token must not be reported as a scope problem", err)
+			}
+		})
+	}
+}
+
+func TestValidateInsufficientScopeReturnsClaims(t *testing.T) {
+	key := newECKey(t, "ec-1")
+	validator, _ := newTestValidator(t, key)
+	claims, err := validator.Validate(context.Background(), key.sign(t, withClaims(map[string]interface{}{"scope": "other"})), testNow)
+	if !errors.Is(err, ErrInsufficientScope) || claims == nil || claims.Subject != "user-1" {
+		t.Errorf("Validate() = %+v, %v, want the claims and ErrInsufficientScope", claims, err)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey := newRSAKey(t, "2024")
+	newKey := newECKey(t, "2025")
+	validator, file := newTestValidator(t, oldKey)
+
+	validate := func(key *testKey) error {
+		_, err := validator.Validate(context.Background(), key.sign(t, validClaims()), testNow)
+		return err
+	}
+	if err := validate(oldKey); err != nil {
+		t.Fatalf("token of the current key: %v", err)
+	}
+	if err := validate(newKey); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of a key not yet published = %v, want ErrInvalidToken", err)
+	}
+
+	// A token with an unknown kid reads the key set again
+	writeJWKS(t, file, newKey)
+	if err := validate(newKey); err != nil {
+		t.Fatalf("token of the rotated key: %v", err)
+	}
+	if err := validate(oldKey); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of the retired key = %v, want ErrInvalidToken", err)
+	}
+
+	// Unknown kids do not read the key set again within MinRefresh
+	validator.Keys.MinRefresh = time.Hour
+	newest := newRSAKey(t, "2026")
+	writeJWKS(t, file, newKey, newest)
+	if err := validate(newest); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of a key published within MinRefresh = %v, want ErrInvalidToken", err)
+	}
+	if err := validate(newKey); err != nil {
+		t.Fatalf("token of a known key: %v", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1").jwk()
+	encryption := newRSAKey(t, "enc-1").jwk()
+	encryption["use"] = "enc"
+	symmetric := map[string]interface{}{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}
+	offCurve := newECKey(t, "ec-1").jwk()
+	offCurve["y"] = offCurve["x"]
+
+	tests := []struct {
+		name    string
+		keys    []map[string]interface{}
+		kids    []string
+		wantErr bool
+	}{
+		{name: "signing keys only", keys: []map[string]interface{}{rsaKey, encryption, symmetric}, kids: []string{"rsa-1"}},
+		{name: "no signing keys", keys: []map[string]interface{}{encryption, symmetric}, wantErr: true},
+		{name: "EC point off the curve", keys: []map[string]interface{}{rsaKey, offCurve}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]interface{}{"keys": tt.keys})
+			keys, err := parseJWKS(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWKS() error = %v, want error %v", err, tt.wantErr)
+			}
+			var kids []string
+			for _, key := range keys {
+				kids = append(kids, key.kid)
+			}
+			if strings.Join(kids, ",") != strings.Join(tt.kids, ",") {
+				t.Errorf("parseJWKS() kids = %v, want %v", kids, tt.kids)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ResourceMetadata is the OAuth 2.0 Protected Resource Metadata document
+// (RFC 9728) MCP clients read to find the authorization server.
+type ResourceMetadata struct {
+	Resource               string   `json:"resource"`
+	AuthorizationServers   []string `json:"authorization_servers,omitempty"`
+	ScopesSupported        []string `json:"scopes_supported,omitempty"`
+	BearerMethodsSupported []string `json:"bearer_methods_supported"`
+	ResourceName           string   `json:"resource_name,omitempty"`
+}
+
+// WellKnownPath is the path of the metadata of a resource at the root of its
+// host. Resources with a path serve it at WellKnownPath followed by that path.
+const WellKnownPath = "/.well-known/oauth-protected-resource"
+
+// MetadataURL returns the URL of the metadata of a resource such as
+// https://proxy.example.com/mcp, which is
+// https://proxy.example.com/.well-known/oauth-protected-resource/mcp.
+func MetadataURL(resource string) (string, error) {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("resource %q is not an absolute URL", resource)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: WellKnownPath + path}).String(), nil
+}
+
+// Challenge returns the WWW-Authenticate header of a response that refuses a
+// request, pointing the client at the resource metadata. err is nil when the
+// request carried no token at all.
+func Challenge(metadataURL string, err error, scopes []string) string {
+	params := []string{fmt.Sprintf("resource_metadata=%q", metadataURL)}
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrInsufficientScope):
+		params = append(params, `error="insufficient_scope"`)
+		if len(scopes) > 0 {
+			params = append(params, fmt.Sprintf("scope=%q", strings.Join(scopes, " ")))
+		}
+	default:
+		params = append(params, `error="invalid_token"`, fmt.Sprintf("error_description=%q", sanitize(err.Error())))
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// sanitize keeps a description within the characters RFC 6750 allows.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '\''
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,62 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMetadataURL(t *testing.T) {
+	tests := []struct {
+		resource string
+		want     string
+		wantErr  bool
+	}{
+		{resource: "https://proxy.example.com/mcp", want: "https://proxy.example.com/.well-known/oauth-protected-resource/mcp"},
+		{resource: "https://proxy.example.com/", want: "https://proxy.example.com/.well-known/oauth-protected-resource"},
+		{resource: "https://proxy.example.com:8443/team/mcp/", want: "https://proxy.example.com:8443/.well-known/oauth-protected-resource/team/mcp"},
+		{resource: "/mcp", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := MetadataURL(tt.resource)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("MetadataURL(%q) = %q, %v, want %q", tt.resource, got, err, tt.want)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	const metadata = "https://proxy.example.com/.well-known/oauth-protected-resource/mcp"
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "no token",
+			want: `Bearer resource_metadata="` + metadata + `"`,
+		},
+		{
+			name: "invalid token",
+			err:  fmt.Errorf("%w: issuer \"x\" is not \"y\"\n", ErrInvalidToken),
+			want: `Bearer resource_metadata="` + metadata + `", error="invalid_token", error_description="invalid token: issuer 'x' is not 'y''"`,
+		},
+		{
+			name: "insufficient scope",
+			err:  fmt.Errorf("%w: missing scope %q", ErrInsufficientScope, "mcp"),
+			want: `Bearer resource_metadata="` + metadata + `", error="insufficient_scope", scope="mcp figma-mcp:admin"`,
+		},
+		{
+			name: "other error",
+			err:  errors.New("unknown key"),
+			want: `Bearer resource_metadata="` + metadata + `", error="invalid_token", error_description="unknown key"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Challenge(metadata, tt.err, []string{"mcp", "figma-mcp:admin"}); got != tt.want {
+				t.Errorf("Challenge() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/oauth"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+const testMetadataURL = "https://proxy.example.com/.well-known/oauth-protected-resource/mcp"
+
+// newTestOAuth configures OAuth through the environment, as main does, with a
+// JWKS file holding a single ES256 key, and returns a function that signs
+// tokens with it.
+func newTestOAuth(t *testing.T) (*oauthResource, func(claims map[string]interface{}) string) {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	coordinate := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]interface{}{{
+		"kty": "EC", "crv": "P-256", "kid": "test", "alg": "ES256", "use": "sig",
+		"x": coordinate(private.X.FillBytes(make([]byte, 32))),
+		"y": coordinate(private.Y.FillBytes(make([]byte, 32))),
+	}}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OAUTH_JWKS", file)
+	t.Setenv("OAUTH_RESOURCE", "https://proxy.example.com/mcp")
+	t.Setenv("OAUTH_ISSUER", "https://auth.example.com")
+	t.Setenv("OAUTH_AUDIENCE", "")
+	t.Setenv("OAUTH_AUTHORIZATION_SERVERS", "")
+	t.Setenv("OAUTH_REQUIRED_SCOPES", "mcp")
+	resource := loadOAuth()
+
+	sign := func(claims map[string]interface{}) string {
+		signed := encode(map[string]string{"alg": "ES256", "kid": "test", "typ": "JWT"}) + "." + encode(claims)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	return resource, sign
+}
+
+func TestOAuthRejections(t *testing.T) {
+	upstream := newJSONUpstream(t)
+	resource, sign := newTestOAuth(t)
+	cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+	cfg.auth = &authenticator{oauth: resource}
+	handler := newMCPHandler(cfg)
+
+	claims := func(scope string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   "https://auth.example.com",
+			"sub":   "user-1",
+			"aud":   "https://proxy.example.com/mcp",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": scope,
+		}
+	}
+	expired := claims("mcp")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name      string
+		token     string
+		status    int
+		challenge string
+	}{
+		{
+			name:      "no token",
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer resource_metadata="` + testMetadataURL + `"`,
+		},
+		{
+			name:      "expired token",
+			token:     sign(expired),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer resource_metadata="` + testMetadataURL + `", error="invalid_token", error_description="invalid token: expired at ` + time.Unix(expired["exp"].(int64), 0).UTC().Format(time.RFC3339) + `"`,
+		},
+		{
+			name:      "missing required scope",
+			token:     sign(claims("figma-mcp:admin")),
+			status:    http.StatusForbidden,
+			challenge: `Bearer resource_metadata="` + testMetadataURL + `", error="insufficient_scope", scope="mcp"`,
+		},
+		{
+			name:   "valid token",
+			token:  sign(claims("mcp figma-mcp:files:abc*")),
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newToolCallRequest(1, "get_code", map[string]interface{}{"fileKey": "abc123", "nodeId": "1:2"})
+			req.Header.Set("Accept", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rw.Code, tt.status, rw.Body.String())
+			}
+			if got := rw.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("WWW-Authenticate =\n%s\nwant\n%s", got, tt.challenge)
+			}
+			var body struct {
+				ID    json.RawMessage `json:"id"`
+				Error *struct {
+					Code int `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON-RPC body %q: %v", rw.Body.String(), err)
+			}
+			if tt.status == http.StatusOK {
+				if body.Error != nil {
+					t.Errorf("valid token got error %d", body.Error.Code)
+				}
+				return
+			}
+			if body.Error == nil || body.Error.Code != codeUnauthorized || string(body.ID) != "null" {
+				t.Errorf("body = %s, want error %d with a null id", rw.Body.String(), codeUnauthorized)
+			}
+		})
+	}
+}
+
+func TestOAuthMetadataDocument(t *testing.T) {
+	resource, _ := newTestOAuth(t)
+	rw := httptest.NewRecorder()
+	resource.serveMetadata(rw, httptest.NewRequest(http.MethodGet, oauth.WellKnownPath+"/mcp", nil))
+
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"resource":                 "https://proxy.example.com/mcp",
+		"authorization_servers":    []interface{}{"https://auth.example.com"},
+		"scopes_supported":         []interface{}{"mcp", "figma-mcp:admin"},
+		"bearer_methods_supported": []interface{}{"header"},
+		"resource_name":            "Figma MCP Proxy",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+	if resource.metadataURL != testMetadataURL {
+		t.Errorf("metadata URL = %q, want %q", resource.metadataURL, testMetadataURL)
+	}
+}
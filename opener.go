@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// loadOpener selects how designs are opened from the OPENER environment variable:
+//   - exec (default): the operating system's URL handler
+//   - command: the templated command in OPENER_COMMAND
+//   - http: POST to the local agent at OPENER_URL
+//   - noop: open nothing, for headless runs
+func loadOpener() util.Opener {
+	kind := os.Getenv("OPENER")
+	log.Printf("[MAIN] Environment variable OPENER: %q", kind)
+
+	switch kind {
+	case "", "exec":
+		log.Printf("[MAIN] Using operating system opener")
+		return util.ExecOpener{}
+	case "command":
+		command := os.Getenv("OPENER_COMMAND")
+		log.Printf("[MAIN] Environment variable OPENER_COMMAND: %q", command)
+		args := strings.Fields(command)
+		// A JSON array allows arguments that contain spaces
+		if strings.HasPrefix(strings.TrimSpace(command), "[") {
+			if err := json.Unmarshal([]byte(command), &args); err != nil {
+				log.Fatalf("[MAIN] Failed to parse OPENER_COMMAND as a JSON array: %v", err)
+			}
+		}
+		opener, err := util.NewCommandOpener(args)
+		if err != nil {
+			log.Fatalf("[MAIN] Invalid OPENER_COMMAND: %v", err)
+		}
+		log.Printf("[MAIN] Using custom command opener: %v", args)
+		return opener
+	case "http":
+		endpoint := os.Getenv("OPENER_URL")
+		log.Printf("[MAIN] Environment variable OPENER_URL: %q", endpoint)
+		if endpoint == "" {
+			log.Fatalf("[MAIN] OPENER=http requires OPENER_URL")
+		}
+		log.Printf("[MAIN] Using HTTP callback opener: %s", endpoint)
+		return &util.HTTPOpener{Endpoint: endpoint, Client: &http.Client{Timeout: 10 * time.Second}}
+	case "noop":
+		log.Printf("[MAIN] Using no-op opener, designs will not be opened")
+		return util.NoopOpener{}
+	default:
+		log.Fatalf("[MAIN] Unknown OPENER %q, expected exec, command, http or noop", kind)
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// durationFromEnv reads a time.Duration such as "250ms" or "20s" from the named
+// environment variable, falling back to def when it is unset.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	log.Printf("[MAIN] Environment variable %s: %q", name, value)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("[MAIN] Invalid duration for %s: %q", name, value)
+	}
+	return d
+}
+
+// loadReadinessWaiter configures polling of the upstream Figma MCP server after
+// a design is opened, replacing a fixed launch delay. Probes are sent through
+// transport, like proxied requests.
+func loadReadinessWaiter(target *url.URL, transport http.RoundTripper) *util.ReadinessWaiter {
+	endpoint := *target
+	endpoint.Path = strings.TrimSuffix(target.Path, "/") + "/mcp"
+
+	probeTool := os.Getenv("READY_PROBE_TOOL")
+	log.Printf("[MAIN] Environment variable READY_PROBE_TOOL: %q", probeTool)
+	if probeTool == "" {
+		probeTool = "get_metadata"
+	}
+
+	// Figma's get_metadata answers with the layer XML of the node, which does
+	// not contain the file key, so verifying the file is opt-in
+	verifyFile := false
+	if value := os.Getenv("READY_VERIFY_FILE"); value != "" {
+		log.Printf("[MAIN] Environment variable READY_VERIFY_FILE: %q", value)
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("[MAIN] Invalid READY_VERIFY_FILE, expected true or false: %q", value)
+		}
+		verifyFile = parsed
+	}
+
+	interval := durationFromEnv("READY_POLL_INTERVAL", 250*time.Millisecond)
+	timeout := durationFromEnv("READY_TIMEOUT", 20*time.Second)
+	log.Printf("[MAIN] Readiness probe: %s on %s every %v for up to %v, verifying the file key: %v", probeTool, endpoint.String(), interval, timeout, verifyFile)
+
+	return &util.ReadinessWaiter{
+		Probe: &util.MCPProbe{
+			Endpoint:   endpoint.String(),
+			Tool:       probeTool,
+			Client:     &http.Client{Transport: transport, Timeout: interval * 8},
+			VerifyFile: verifyFile,
+		},
+		Interval: interval,
+		Timeout:  timeout,
+	}
+}
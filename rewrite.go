@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/rules"
+	"github.com/bitovi/figma-mcp-proxy/sse"
+)
+
+// toolsRewriter rewrites the tools/list responses of the upstream server, and
+// the results of tool calls forwarded without opening their design.
+type toolsRewriter struct {
+	rules      *rules.RuleSet
+	proxyTools *virtualTools
+	// key hides the tools the client may not call
+	key *keystore.Key
+	// warning, when set, is added to tool results instead of rewriting tools
+	warning string
+}
+
+// withKey returns a rewriter that also hides the tools key may not call.
+func (rw *toolsRewriter) withKey(key *keystore.Key) *toolsRewriter {
+	copied := *rw
+	copied.key = key
+	return &copied
+}
+
+// withWarning returns a rewriter that adds warning to the tool results of the
+// given requests.
+func (rw *toolsRewriter) withWarning(warning string) *toolsRewriter {
+	copied := *rw
+	copied.warning = warning
+	return &copied
+}
+
+// loadRewriteRules reads the tools/list rewrite rules from the JSON file named
+// by REWRITE_RULES, or uses the built-in rules when it is not set.
+func loadRewriteRules() *rules.RuleSet {
+	name := os.Getenv("REWRITE_RULES")
+	log.Printf("[MAIN] Environment variable REWRITE_RULES: %q", name)
+	if name == "" {
+		log.Printf("[MAIN] Using built-in rewrite rules")
+		return rules.Default()
+	}
+	ruleSet, err := rules.Load(name)
+	if err != nil {
+		log.Fatalf("[MAIN] Failed to load rewrite rules: %v", err)
+	}
+	log.Printf("[MAIN] Loaded %d rewrite rules from %s", len(ruleSet.Rules), name)
+	return ruleSet
+}
+
+// rewriteMessages rewrites every response to the given requests in a JSON-RPC
+// body and reports whether anything changed. Bodies that are not JSON-RPC are
+// left alone.
+func (rw *toolsRewriter) rewriteMessages(reqID string, data []byte, ids map[string]bool) ([]byte, bool) {
+	payload, err := jsonrpc.Parse(data)
+	if err != nil {
+		log.Printf("[MODIFY_RESPONSE] [%s] Passing through non JSON-RPC data: %v", reqID, err)
+		return data, false
+	}
+
+	changed := false
+	for _, msg := range payload.Messages {
+		if !msg.IsResponse() || msg.Result == nil || !ids[msg.ID.Key()] {
+			continue
+		}
+		if rw.warning != "" {
+			if err := rw.addToolResultWarning(reqID, msg); err != nil {
+				log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to add warning to tool result %s: %v", reqID, msg.ID, err)
+				continue
+			}
+		} else if err := rw.rewriteToolsListResult(reqID, msg); err != nil {
+			log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to rewrite tools/list response %s: %v", reqID, msg.ID, err)
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return data, false
+	}
+
+	modified, err := payload.Marshal()
+	if err != nil {
+		log.Printf("[MODIFY_RESPONSE] [%s] ERROR: Failed to marshal modified response: %v", reqID, err)
+		return data, false
+	}
+	return modified, true
+}
+
+// rewriteEvent rewrites the responses carried by an SSE event and
+// passes every other event through unchanged.
+func (rw *toolsRewriter) rewriteEvent(reqID string, ids map[string]bool) sse.TransformFunc {
+	return func(event *sse.Event) *sse.Event {
+		if event.Data == "" {
+			return event
+		}
+		if data, changed := rw.rewriteMessages(reqID, []byte(event.Data), ids); changed {
+			log.Printf("[MODIFY_RESPONSE] [%s] Rewrote event (id %q), length %d -> %d", reqID, event.ID, len(event.Data), len(data))
+			event.Data = string(data)
+			event.Raw = nil
+		}
+		return event
+	}
+}
+
+// rewriteResponseBody rewrites the responses in an upstream response
+// according to its Content-Type. Event streams are rewritten incrementally and
+// JSON bodies in one go; any other body is passed through untouched. All other
+// headers, including Content-Type, are kept as they are.
+func (rw *toolsRewriter) rewriteResponseBody(reqID string, resp *http.Response, ids map[string]bool) error {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Printf("[MODIFY_RESPONSE] [%s] Unparseable Content-Type %q, skipping modification", reqID, contentType)
+		return nil
+	}
+
+	switch mediaType {
+	case "text/event-stream":
+		// The length of the rewritten stream is not known up front
+		log.Printf("[MODIFY_RESPONSE] [%s] Rewriting event stream incrementally", reqID)
+		resp.Body = sse.NewTransformReader(resp.Body, rw.rewriteEvent(reqID, ids))
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	case "application/json":
+		log.Printf("[MODIFY_RESPONSE] [%s] Rewriting JSON response body", reqID)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if modified, changed := rw.rewriteMessages(reqID, body, ids); changed {
+			log.Printf("[MODIFY_RESPONSE] [%s] Rewrote JSON body, length %d -> %d", reqID, len(body), len(modified))
+			body = modified
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	default:
+		log.Printf("[MODIFY_RESPONSE] [%s] Unsupported Content-Type %q, skipping modification", reqID, contentType)
+	}
+	return nil
+}
+
+// rewriteToolsListResult applies the rewrite rules to the upstream tools and
+// lists the proxy's own tools after them, on the last page of the list.
+func (rw *toolsRewriter) rewriteToolsListResult(reqID string, msg *jsonrpc.Message) error {
+	var result map[string]interface{}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		return err
+	}
+	if result == nil {
+		log.Printf("[MODIFY_RESPONSE] [%s] No result object found in response", reqID)
+		return nil
+	}
+
+	log.Printf("[MODIFY_RESPONSE] [%s] Found result object in response", reqID)
+	if tools, ok := result["tools"].([]interface{}); ok {
+		log.Printf("[MODIFY_RESPONSE] [%s] Found %d tools in response", reqID, len(tools))
+		kept, changes := rw.rules.Apply(tools)
+		toolsModified := map[string]bool{}
+		for _, change := range changes {
+			log.Printf("[MODIFY_RESPONSE] [%s] Rule %q applied %s to tool %s", reqID, change.Rule, change.Action, change.Tool)
+			toolsModified[change.Tool] = true
+		}
+		log.Printf("[MODIFY_RESPONSE] [%s] Tool modification completed, %d tools modified, %d removed", reqID, len(toolsModified), len(tools)-len(kept))
+
+		// A paginated list gets the proxy tools once, on its last page
+		if cursor, _ := result["nextCursor"].(string); cursor == "" {
+			kept = append(kept, rw.proxyTools.definitions()...)
+			log.Printf("[MODIFY_RESPONSE] [%s] Added %d proxy tools", reqID, len(rw.proxyTools.tools))
+		} else {
+			log.Printf("[MODIFY_RESPONSE] [%s] More tools follow, not adding proxy tools to this page", reqID)
+		}
+
+		if rw.key.Restricted() {
+			allowed := kept[:0]
+			for _, tool := range kept {
+				toolMap, _ := tool.(map[string]interface{})
+				name, _ := toolMap["name"].(string)
+				if !rw.key.AllowsTool(name) {
+					log.Printf("[MODIFY_RESPONSE] [%s] Hiding tool %s, not allowed for key %q", reqID, name, rw.key.Name)
+					continue
+				}
+				allowed = append(allowed, tool)
+			}
+			kept = allowed
+		}
+		result["tools"] = kept
+	} else {
+		log.Printf("[MODIFY_RESPONSE] [%s] No tools array found in result", reqID)
+	}
+
+	modified, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg.Result = modified
+	return nil
+}
+
+// addToolResultWarning puts the rewriter's warning in front of the content of a
+// tool result, so that the agent reads it before the result itself.
+func (rw *toolsRewriter) addToolResultWarning(reqID string, msg *jsonrpc.Message) error {
+	var result map[string]interface{}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		return err
+	}
+	if result == nil {
+		log.Printf("[MODIFY_RESPONSE] [%s] No result object found in response", reqID)
+		return nil
+	}
+	content, _ := result["content"].([]interface{})
+	warning := map[string]interface{}{"type": "text", "text": rw.warning}
+	result["content"] = append([]interface{}{warning}, content...)
+
+	modified, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	log.Printf("[MODIFY_RESPONSE] [%s] Added open failure warning to tool result %s", reqID, msg.ID)
+	msg.Result = modified
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/rules"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// TestHiddenToolsAreNotCallable checks that tools renamed or removed by the
+// rewrite rules cannot be called by their upstream name, which would bypass
+// a key's tool scopes on the listed name.
+func TestHiddenToolsAreNotCallable(t *testing.T) {
+	var mu sync.Mutex
+	var called []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Params struct {
+				Name string `json:"name"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		called = append(called, msg.Params.Name)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"upstream"}]}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+	ruleSet, err := rules.Parse([]byte(`{"rules": [
+		{"match": {"tool": "get_code"}, "actions": [{"type": "rename", "name": "figma_get_code"}]},
+		{"match": {"tool": "get_screenshot"}, "actions": [{"type": "remove"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.rules = ruleSet
+	handler := newMCPHandler(cfg)
+	key := &keystore.Key{Name: "no-code", Scopes: keystore.Scopes{Tools: &keystore.ToolPolicy{Deny: []string{"figma_get_code"}}}}
+
+	call := func(tool string, key *keystore.Key) rpcResponse {
+		req := newToolCallRequest(1, tool, map[string]interface{}{"nodeId": "1:2"})
+		if key != nil {
+			req = req.WithContext(withAPIKey(req.Context(), key))
+		}
+		return serveRPC(t, handler, req)
+	}
+
+	tests := []struct {
+		name string
+		tool string
+		key  *keystore.Key
+		code int
+	}{
+		{name: "renamed tool by its upstream name", tool: "get_code", code: jsonrpc.CodeMethodNotFound},
+		{name: "renamed tool by its upstream name with a scoped key", tool: "get_code", key: key, code: jsonrpc.CodeMethodNotFound},
+		{name: "denied renamed tool", tool: "figma_get_code", key: key, code: codeToolForbidden},
+		{name: "removed tool", tool: "get_screenshot", code: jsonrpc.CodeMethodNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(tt.tool, tt.key)
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Errorf("response = result %+v, error %+v, want error %d", resp.Result, resp.Error, tt.code)
+			}
+		})
+	}
+	mu.Lock()
+	if len(called) != 0 {
+		t.Errorf("upstream was called with %v, want no calls", called)
+	}
+	mu.Unlock()
+
+	if resp := call("figma_get_code", nil); resp.Error != nil {
+		t.Fatalf("call to the listed name failed: %+v", resp.Error)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(called) != 1 || called[0] != "get_code" {
+		t.Errorf("upstream was called with %v, want get_code", called)
+	}
+}
+
+// newToolsListUpstream returns an upstream that answers tools/list with the
+// given tools, as a JSON body with a Content-Length.
+func newToolsListUpstream(t *testing.T, tools string) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID json.RawMessage `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+		body := `{"jsonrpc":"2.0","id":` + string(msg.ID) + `,"result":{"tools":` + tools + `}}`
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func newToolsListRequest(id string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":`+id+`,"method":"tools/list"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	return req
+}
+
+// listedTools returns the names of the tools in a tools/list response.
+func listedTools(t *testing.T, body []byte) []string {
+	t.Helper()
+	var resp struct {
+		Result struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid tools/list response %q: %v", body, err)
+	}
+	var names []string
+	for _, tool := range resp.Result.Tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// TestToolsListJSONRewrite checks that a tools/list answered with a JSON body
+// is rewritten, and that its Content-Length matches the rewritten body.
+func TestToolsListJSONRewrite(t *testing.T) {
+	upstream := newToolsListUpstream(t, `[{"name":"get_code","inputSchema":{"type":"object"}},{"name":"get_screenshot","inputSchema":{"type":"object"}}]`)
+	cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+	ruleSet, err := rules.Parse([]byte(`{"rules": [
+		{"match": {"tool": "get_code"}, "actions": [{"type": "rename", "name": "figma_get_code"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.rules = ruleSet
+
+	rec := httptest.NewRecorder()
+	newMCPHandler(cfg).ServeHTTP(rec, newToolsListRequest(`"list-1"`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+	if !strings.Contains(rec.Body.String(), `"id":"list-1"`) {
+		t.Errorf("response %s does not echo the request id", rec.Body)
+	}
+	want := []string{"figma_get_code", "get_screenshot", "open_figma_design", "get_active_design", "parse_figma_url", "set_current_design"}
+	if got := listedTools(t, rec.Body.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("tools = %v, want %v", got, want)
+	}
+}
+
+// TestRewriteResponseBodyLeavesOtherResponses checks that a JSON body without
+// a response to the rewritten request keeps its bytes and length.
+func TestRewriteResponseBodyLeavesOtherResponses(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":2,  "result":{"tools":[]}}`
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": {"application/json; charset=utf-8"}, "Content-Length": {strconv.Itoa(len(body))}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	rw := &toolsRewriter{rules: rules.Default(), proxyTools: newVirtualTools()}
+	if err := rw.rewriteResponseBody("test", resp, map[string]bool{"1": true}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	if string(got) != body {
+		t.Errorf("body = %s, want %s", got, body)
+	}
+	if resp.ContentLength != int64(len(body)) || resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length = %d (%s), want %d", resp.ContentLength, resp.Header.Get("Content-Length"), len(body))
+	}
+}
+
+// TestToolsListPagination checks that the proxy tools are listed once, after
+// the last page of a paginated tools/list.
+func TestToolsListPagination(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				Cursor string `json:"cursor"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&msg)
+		result := `{"tools":[{"name":"get_code"}],"nextCursor":"page-2"}`
+		if msg.Params.Cursor == "page-2" {
+			result = `{"tools":[{"name":"get_screenshot"}]}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":`+string(msg.ID)+`,"result":`+result+`}`)
+	}))
+	t.Cleanup(upstream.Close)
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{}))
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "first page",
+			body: `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+			want: []string{"get_code"},
+		},
+		{
+			name: "last page",
+			body: `{"jsonrpc":"2.0","id":2,"method":"tools/list","params":{"cursor":"page-2"}}`,
+			want: []string{"get_screenshot", "open_figma_design", "get_active_design", "parse_figma_url", "set_current_design"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json, text/event-stream")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if got := listedTools(t, rec.Body.Bytes()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tools = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestToolsListHidesDisallowedTools checks that a key's tool policy hides the
+// upstream and proxy tools it may not call from its tools/list.
+func TestToolsListHidesDisallowedTools(t *testing.T) {
+	upstream := newToolsListUpstream(t, `[{"name":"get_code"},{"name":"get_screenshot"},{"name":"create_design_system_rules"}]`)
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{}))
+
+	tests := []struct {
+		name   string
+		policy *keystore.ToolPolicy
+		want   []string
+	}{
+		{
+			name: "unrestricted",
+			want: []string{"get_code", "get_screenshot", "create_design_system_rules", "open_figma_design", "get_active_design", "parse_figma_url", "set_current_design"},
+		},
+		{
+			name:   "allow list",
+			policy: &keystore.ToolPolicy{Allow: []string{"get_*"}},
+			want:   []string{"get_code", "get_screenshot", "get_active_design"},
+		},
+		{
+			name:   "allow and deny",
+			policy: &keystore.ToolPolicy{Allow: []string{"get_*"}, Deny: []string{"get_screenshot"}},
+			want:   []string{"get_code", "get_active_design"},
+		},
+		{
+			name:   "deny list",
+			policy: &keystore.ToolPolicy{Deny: []string{"*_design*"}},
+			want:   []string{"get_code", "get_screenshot", "parse_figma_url"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &keystore.Key{Name: "scoped", Scopes: keystore.Scopes{Tools: tt.policy}}
+			req := newToolsListRequest("1")
+			req = req.WithContext(withAPIKey(req.Context(), key))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if got := listedTools(t, rec.Body.Bytes()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tools = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/sse"
+)
+
+// JSON-RPC errors for requests the proxy refuses or fails to forward, from the
+// implementation-defined server error range. Clients may rely on these codes.
+const (
+	codeToolForbidden       = -32001
+	codeFileForbidden       = -32002
+	codeRequestTooLarge     = -32003
+	codeUnauthorized        = -32004
+	codeUpstreamUnavailable = -32005
+	codeUpstreamTimeout     = -32006
+	codeDesignOpenFailed    = -32007
+)
+
+// wantsEventStream reports whether a client accepts text/event-stream but not
+// JSON, such as a GET that opens a stream. Everything else is answered with a
+// JSON body.
+func wantsEventStream(r *http.Request) bool {
+	eventStream := false
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			switch mediaType {
+			case "application/json", "application/*", "*/*":
+				return false
+			case "text/event-stream":
+				eventStream = true
+			}
+		}
+	}
+	return eventStream
+}
+
+// writeRPCMessage answers a request locally with a JSON-RPC message instead of
+// forwarding it upstream.
+func writeRPCMessage(w http.ResponseWriter, r *http.Request, reqID string, msg *jsonrpc.Message) {
+	writeRPCMessageStatus(w, r, reqID, http.StatusOK, msg)
+}
+
+// writeRPCMessageStatus writes msg as a JSON body, or as a single event when
+// the client only accepts an event stream.
+func writeRPCMessageStatus(w http.ResponseWriter, r *http.Request, reqID string, status int, msg *jsonrpc.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[RPC_RESPONSE] [%s] ERROR: Failed to encode JSON-RPC response: %v", reqID, err)
+		http.Error(w, "Failed to encode JSON-RPC response", http.StatusInternalServerError)
+		return
+	}
+	if wantsEventStream(r) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(status)
+		err = sse.WriteEvent(w, &sse.Event{Event: "message", Data: string(data)})
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err = w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("[RPC_RESPONSE] [%s] ERROR: Failed to write JSON-RPC response: %v", reqID, err)
+	}
+}
+
+// writeRPCError answers a request with a JSON-RPC error response instead of
+// forwarding it upstream.
+func writeRPCError(w http.ResponseWriter, r *http.Request, reqID string, id *jsonrpc.ID, code int, message string) {
+	writeRPCErrorStatus(w, r, reqID, http.StatusOK, id, code, message)
+}
+
+// writeRPCErrorStatus is writeRPCError with an HTTP status other than 200 OK,
+// for requests that were refused as a whole or could not be forwarded.
+func writeRPCErrorStatus(w http.ResponseWriter, r *http.Request, reqID string, status int, id *jsonrpc.ID, code int, message string) {
+	log.Printf("[RPC_RESPONSE] [%s] Responding to ID %s with status %d and JSON-RPC error %d: %s", reqID, id, status, code, message)
+	writeRPCMessageStatus(w, r, reqID, status, jsonrpc.NewErrorResponse(id, code, message, nil))
+}
+
+// writeRPCResult answers a request with a JSON-RPC result response instead of
+// forwarding it upstream.
+func writeRPCResult(w http.ResponseWriter, r *http.Request, reqID string, id *jsonrpc.ID, result interface{}) {
+	msg, err := jsonrpc.NewResponse(id, result)
+	if err != nil {
+		writeRPCError(w, r, reqID, id, jsonrpc.CodeInternalError, err.Error())
+		return
+	}
+	log.Printf("[RPC_RESPONSE] [%s] Responding to ID %s with a local result", reqID, id)
+	writeRPCMessage(w, r, reqID, msg)
+}
+
+// toolResult is the result of a tools/call answered by the proxy.
+type toolResult struct {
+	Content           []toolContent `json:"content"`
+	StructuredContent interface{}   `json:"structuredContent,omitempty"`
+	IsError           bool          `json:"isError,omitempty"`
+}
+
+type toolContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// textToolResult returns a tool result with a single text content block.
+func textToolResult(text string, isError bool) toolResult {
+	return toolResult{Content: []toolContent{{Type: "text", Text: text}}, IsError: isError}
+}
+
+// errRewriteFailed is returned by ModifyResponse when an upstream response
+// could not be rewritten.
+var errRewriteFailed = errors.New("failed to rewrite the upstream response")
+
+// proxyErrorResponse maps an error forwarding a request upstream to the HTTP
+// status and JSON-RPC error answering it.
+func proxyErrorResponse(err error) (status, code int, message string) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errRewriteFailed):
+		return http.StatusBadGateway, jsonrpc.CodeInternalError, err.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, codeUpstreamTimeout, "the Figma MCP server did not respond in time"
+	default:
+		return http.StatusBadGateway, codeUpstreamUnavailable, "the Figma MCP server is unavailable: " + err.Error()
+	}
+}
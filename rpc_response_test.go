@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+func TestWantsEventStream(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   bool
+	}{
+		{accept: nil, want: false},
+		{accept: []string{"text/event-stream"}, want: true},
+		{accept: []string{"text/event-stream; charset=utf-8"}, want: true},
+		{accept: []string{"application/json, text/event-stream"}, want: false},
+		{accept: []string{"text/event-stream", "application/json"}, want: false},
+		{accept: []string{"text/event-stream;q=1, */*;q=0.1"}, want: false},
+		{accept: []string{"application/*"}, want: false},
+		{accept: []string{"text/html, text/event-stream"}, want: true},
+		{accept: []string{"text/html"}, want: false},
+		{accept: []string{"not a media type;;, text/event-stream"}, want: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+		for _, value := range tt.accept {
+			req.Header.Add("Accept", value)
+		}
+		if got := wantsEventStream(req); got != tt.want {
+			t.Errorf("wantsEventStream(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRPCErrorFraming(t *testing.T) {
+	const message = `{"jsonrpc":"2.0","id":"a","error":{"code":-32002,"message":"denied"}}`
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{accept: "application/json, text/event-stream", contentType: "application/json", body: message + "\n"},
+		{accept: "text/event-stream", contentType: "text/event-stream", body: "event: message\ndata: " + message + "\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			writeRPCErrorStatus(rec, req, "test", http.StatusForbidden, jsonrpc.StringID("a"), codeFileForbidden, "denied")
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestProxyErrorResponse(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errors.New("connection refused"))}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   int
+	}{
+		{name: "connection refused", err: refused, status: http.StatusBadGateway, code: codeUpstreamUnavailable},
+		{name: "deadline", err: fmt.Errorf("round trip: %w", context.DeadlineExceeded), status: http.StatusGatewayTimeout, code: codeUpstreamTimeout},
+		{name: "network timeout", err: &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, status: http.StatusGatewayTimeout, code: codeUpstreamTimeout},
+		{name: "rewrite failure", err: fmt.Errorf("%w: bad body", errRewriteFailed), status: http.StatusBadGateway, code: jsonrpc.CodeInternalError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code, message := proxyErrorResponse(tt.err)
+			if status != tt.status || code != tt.code || message == "" {
+				t.Errorf("proxyErrorResponse = %d, %d, %q, want %d, %d", status, code, message, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+// TestUpstreamFailures checks the JSON-RPC errors answering tool calls the
+// upstream could not serve.
+func TestUpstreamFailures(t *testing.T) {
+	t.Run("unreachable", func(t *testing.T) {
+		upstream := httptest.NewServer(http.NotFoundHandler())
+		upstream.Close()
+		handler := newMCPHandler(newTestConfig(t, upstream.URL, &util.RecordingOpener{}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newToolCallRequest(3, "get_code", nil))
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("status = %d, want 502", rec.Code)
+		}
+		resp := decodeRPCResponse(t, rec.Body.Bytes())
+		if resp.Error == nil || resp.Error.Code != codeUpstreamUnavailable {
+			t.Errorf("error = %+v, want %d", resp.Error, codeUpstreamUnavailable)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		t.Cleanup(upstream.Close)
+		t.Cleanup(func() { close(release) })
+		cfg := newTestConfig(t, upstream.URL, &util.RecordingOpener{})
+		cfg.transport = &http.Transport{ResponseHeaderTimeout: 50 * time.Millisecond}
+		handler := newMCPHandler(cfg)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newToolCallRequest(4, "get_code", nil))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("status = %d, want 504", rec.Code)
+		}
+		resp := decodeRPCResponse(t, rec.Body.Bytes())
+		if resp.Error == nil || resp.Error.Code != codeUpstreamTimeout {
+			t.Errorf("error = %+v, want %d", resp.Error, codeUpstreamTimeout)
+		}
+	})
+}
+
+// TestAuthFailureBody checks the error answering requests without a valid API
+// key, which carries a null id because the body is not read.
+func TestAuthFailureBody(t *testing.T) {
+	key := &keystore.Key{Name: "ci"}
+	if err := key.SetSecret("good-secret"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestConfig(t, newJSONUpstream(t).URL, &util.RecordingOpener{})
+	cfg.auth = &authenticator{keys: &keyStore{store: &keystore.Store{Keys: []*keystore.Key{key}}}}
+	handler := newMCPHandler(cfg)
+
+	tests := []struct {
+		name   string
+		header string
+		accept string
+		status int
+		body   string
+	}{
+		{
+			name:   "missing token",
+			status: http.StatusUnauthorized,
+			body:   `{"jsonrpc":"2.0","id":null,"error":{"code":-32004,"message":"missing bearer token"}}` + "\n",
+		},
+		{
+			name:   "unknown token",
+			header: "Bearer wrong-secret",
+			status: http.StatusUnauthorized,
+			body:   `{"jsonrpc":"2.0","id":null,"error":{"code":-32004,"message":"invalid bearer token"}}` + "\n",
+		},
+		{
+			name:   "event stream",
+			header: "Basic Z29vZC1zZWNyZXQ=",
+			accept: "text/event-stream",
+			status: http.StatusUnauthorized,
+			body:   "event: message\ndata: " + `{"jsonrpc":"2.0","id":null,"error":{"code":-32004,"message":"missing bearer token"}}` + "\n\n",
+		},
+		{
+			name:   "valid token",
+			header: "Bearer good-secret",
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newToolCallRequest(5, "get_code", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != "" {
+				t.Errorf("WWW-Authenticate = %q without OAuth", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+// Package rules rewrites the tools of a tools/list result according to
+// declarative rules, such as adding the proxy's figmaUrl parameter to every
+// tool that takes a nodeId.
+//
+// A rule file is a JSON object of the form
+//
+//	{"rules": [{
+//		"name": "figma-file-arguments",
+//		"match": {"tool": "get_*", "hasProperty": "nodeId"},
+//		"actions": [
+//			{"type": "addProperty", "property": "figmaUrl", "schema": {"type": "string"}},
+//			{"type": "appendDescription", "text": "Pass Figma URLs as figmaUrl."}
+//		]
+//	}]}
+//
+// Rules are applied in order to every tool, each one seeing the changes of the
+// rules before it.
+package rules
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Action types
+const (
+	// AddProperty adds or replaces an inputSchema property
+	AddProperty = "addProperty"
+	// Require marks inputSchema properties as required
+	Require = "require"
+	// AppendDescription appends text to the tool description
+	AppendDescription = "appendDescription"
+	// ReplaceDescription replaces the tool description
+	ReplaceDescription = "replaceDescription"
+	// Remove drops the tool from the list
+	Remove = "remove"
+	// Rename lists the tool under another name
+	Rename = "rename"
+	// SetAnnotations merges annotations, such as readOnlyHint, into the tool
+	SetAnnotations = "setAnnotations"
+)
+
+// RuleSet is an ordered list of rules.
+type RuleSet struct {
+	Rules []*Rule `json:"rules"`
+
+	// renamed maps the listed name of renamed tools to their upstream name
+	renamed map[string]string
+	// renamedAway are the upstream names of renamed tools
+	renamedAway map[string]bool
+	// removed are the names of tools removed by their exact name
+	removed map[string]bool
+}
+
+// Rule applies its actions to every tool it matches.
+type Rule struct {
+	// Name identifies the rule in logs, it defaults to its position
+	Name    string    `json:"name,omitempty"`
+	Match   Match     `json:"match"`
+	Actions []*Action `json:"actions"`
+}
+
+// Match selects tools. Every condition that is set must hold, an empty Match
+// matches every tool.
+type Match struct {
+	// Tool is a path.Match glob of the tool name, e.g. get_*
+	Tool string `json:"tool,omitempty"`
+	// HasProperty is the name of a property the tool's inputSchema must have
+	HasProperty string `json:"hasProperty,omitempty"`
+}
+
+// Action is a single change to a tool. Only the fields of its Type are used.
+type Action struct {
+	Type string `json:"type"`
+	// Property and Schema are used by addProperty
+	Property string                 `json:"property,omitempty"`
+	Schema   map[string]interface{} `json:"schema,omitempty"`
+	// Properties are used by require
+	Properties []string `json:"properties,omitempty"`
+	// Text is used by appendDescription and replaceDescription
+	Text string `json:"text,omitempty"`
+	// Name is used by rename
+	Name string `json:"name,omitempty"`
+	// Annotations are used by setAnnotations
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// Change records an action applied to a tool, for logging.
+type Change struct {
+	Rule   string
+	Tool   string
+	Action string
+}
+
+//go:embed default.json
+var defaultRules []byte
+
+// Default returns the built-in rules, which add the figmaUrl, fileKey and
+// fileName parameters to every tool that takes a nodeId.
+func Default() *RuleSet {
+	rules, err := Parse(defaultRules)
+	if err != nil {
+		panic("rules: invalid default rules: " + err.Error())
+	}
+	return rules
+}
+
+// Load reads a rule file.
+func Load(name string) (*RuleSet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return rules, nil
+}
+
+// Parse decodes and validates a rule file. Unknown fields are rejected so that
+// typos do not silently disable a rule.
+func Parse(data []byte) (*RuleSet, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var rules RuleSet
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("rules: %w", err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+func (s *RuleSet) validate() error {
+	s.renamed = make(map[string]string)
+	s.renamedAway = make(map[string]bool)
+	s.removed = make(map[string]bool)
+	for i, rule := range s.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if _, err := path.Match(rule.Match.Tool, ""); err != nil {
+			return fmt.Errorf("rules: %s: invalid tool pattern %q: %w", rule.Name, rule.Match.Tool, err)
+		}
+		if len(rule.Actions) == 0 {
+			return fmt.Errorf("rules: %s: no actions", rule.Name)
+		}
+		for _, action := range rule.Actions {
+			if err := rule.validateAction(action); err != nil {
+				return fmt.Errorf("rules: %s: %s: %w", rule.Name, action.Type, err)
+			}
+			if action.Type == Rename {
+				if previous, exists := s.renamed[action.Name]; exists {
+					return fmt.Errorf("rules: %s: %q is already the new name of %q", rule.Name, action.Name, previous)
+				}
+				s.renamed[action.Name] = rule.Match.Tool
+				s.renamedAway[rule.Match.Tool] = true
+			}
+			// Removals that depend on the schema may keep the tool listed
+			if action.Type == Remove && rule.Match.isExactTool() && rule.Match.HasProperty == "" {
+				s.removed[rule.Match.Tool] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validateAction(action *Action) error {
+	switch action.Type {
+	case AddProperty:
+		if action.Property == "" || action.Schema == nil {
+			return fmt.Errorf("property and schema are required")
+		}
+	case Require:
+		if len(action.Properties) == 0 {
+			return fmt.Errorf("properties are required")
+		}
+	case AppendDescription, ReplaceDescription:
+		if action.Text == "" {
+			return fmt.Errorf("text is required")
+		}
+	case Remove:
+	case Rename:
+		if action.Name == "" {
+			return fmt.Errorf("name is required")
+		}
+		// Calls to the new name are sent upstream under the old one, which
+		// must therefore be a single tool
+		if !r.Match.isExactTool() {
+			return fmt.Errorf("the rule must match a single tool by its exact name")
+		}
+	case SetAnnotations:
+		if len(action.Annotations) == 0 {
+			return fmt.Errorf("annotations are required")
+		}
+	default:
+		return fmt.Errorf("unknown action type")
+	}
+	return nil
+}
+
+// UpstreamName returns the upstream name of a tool renamed by the rules.
+func (s *RuleSet) UpstreamName(name string) (string, bool) {
+	upstream, ok := s.renamed[name]
+	return upstream, ok
+}
+
+// Hidden reports whether the rules remove the tool name by its exact name, or
+// rename it without another tool taking its name. Such tools are not listed
+// under that name, so calls to it must be rejected rather than forwarded.
+func (s *RuleSet) Hidden(name string) bool {
+	if s.removed[name] {
+		return true
+	}
+	_, taken := s.renamed[name]
+	return s.renamedAway[name] && !taken
+}
+
+// Apply rewrites the tools of a tools/list result in place and returns the
+// tools that remain, in order, along with the changes that were made. Entries
+// that are not JSON objects are kept as they are.
+func (s *RuleSet) Apply(tools []interface{}) ([]interface{}, []Change) {
+	var changes []Change
+	kept := tools[:0]
+	for _, entry := range tools {
+		tool, ok := entry.(map[string]interface{})
+		if !ok {
+			kept = append(kept, entry)
+			continue
+		}
+		removed := false
+		for _, rule := range s.Rules {
+			if !rule.Match.matches(tool) {
+				continue
+			}
+			for _, action := range rule.Actions {
+				name, _ := tool["name"].(string)
+				changes = append(changes, Change{Rule: rule.Name, Tool: name, Action: action.Type})
+				if action.Type == Remove {
+					removed = true
+					break
+				}
+				action.apply(tool)
+			}
+			if removed {
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, tool)
+		}
+	}
+	return kept, changes
+}
+
+// isExactTool reports whether the match names a single tool, without glob
+// characters.
+func (m *Match) isExactTool() bool {
+	return m.Tool != "" && !strings.ContainsAny(m.Tool, `*?[\`)
+}
+
+func (m *Match) matches(tool map[string]interface{}) bool {
+	if m.Tool != "" {
+		name, _ := tool["name"].(string)
+		if ok, _ := path.Match(m.Tool, name); !ok {
+			return false
+		}
+	}
+	if m.HasProperty != "" {
+		properties, _ := schemaMap(tool, "properties", false)
+		if _, exists := properties[m.HasProperty]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Action) apply(tool map[string]interface{}) {
+	switch a.Type {
+	case AddProperty:
+		properties, _ := schemaMap(tool, "properties", true)
+		properties[a.Property] = copyValue(a.Schema)
+	case Require:
+		inputSchema, _ := schemaMap(tool, "", true)
+		required, _ := inputSchema["required"].([]interface{})
+		for _, property := range a.Properties {
+			if !containsString(required, property) {
+				required = append(required, property)
+			}
+		}
+		inputSchema["required"] = required
+	case AppendDescription:
+		if description, _ := tool["description"].(string); description != "" {
+			tool["description"] = description + " " + a.Text
+		} else {
+			tool["description"] = a.Text
+		}
+	case ReplaceDescription:
+		tool["description"] = a.Text
+	case Rename:
+		tool["name"] = a.Name
+	case SetAnnotations:
+		annotations, ok := tool["annotations"].(map[string]interface{})
+		if !ok {
+			annotations = map[string]interface{}{}
+			tool["annotations"] = annotations
+		}
+		for key, value := range a.Annotations {
+			annotations[key] = copyValue(value)
+		}
+	}
+}
+
+// schemaMap returns the tool's inputSchema, or the named object inside it,
+// creating them when create is set.
+func schemaMap(tool map[string]interface{}, field string, create bool) (map[string]interface{}, bool) {
+	inputSchema, ok := tool["inputSchema"].(map[string]interface{})
+	if !ok {
+		if !create {
+			return nil, false
+		}
+		inputSchema = map[string]interface{}{"type": "object"}
+		tool["inputSchema"] = inputSchema
+	}
+	if field == "" {
+		return inputSchema, true
+	}
+	value, ok := inputSchema[field].(map[string]interface{})
+	if !ok {
+		if !create {
+			return nil, false
+		}
+		value = map[string]interface{}{}
+		inputSchema[field] = value
+	}
+	return value, true
+}
+
+func containsString(values []interface{}, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copyValue deep copies a decoded JSON value so that rewritten tools never
+// share maps with the rules or with each other.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
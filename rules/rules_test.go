@@ -0,0 +1,163 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// golden is the expected outcome of applying rules to a tools/list result.
+type golden struct {
+	Tools   []interface{} `json:"tools"`
+	Changes []Change      `json:"changes"`
+}
+
+// TestApplyGolden applies testdata/<name>.rules.json, or the default rules when
+// there is none, to testdata/<name>.in.json and compares the result with
+// testdata/<name>.golden.json. Run with -update to rewrite the golden files.
+func TestApplyGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.in.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test cases in testdata")
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".in.json")
+		t.Run(name, func(t *testing.T) {
+			rules := Default()
+			rulesFile := filepath.Join("testdata", name+".rules.json")
+			if _, err := os.Stat(rulesFile); err == nil {
+				if rules, err = Load(rulesFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tools []interface{}
+			if err := json.Unmarshal(data, &tools); err != nil {
+				t.Fatal(err)
+			}
+			var got golden
+			got.Tools, got.Changes = rules.Apply(tools)
+			gotJSON, err := json.MarshalIndent(got, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON = append(gotJSON, '\n')
+
+			goldenFile := filepath.Join("testdata", name+".golden.json")
+			if *update {
+				if err := os.WriteFile(goldenFile, gotJSON, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gotJSON, want) {
+				t.Errorf("Apply result differs from %s:\n%s", goldenFile, gotJSON)
+			}
+		})
+	}
+}
+
+// TestApplyDoesNotShareSchemas checks that tools rewritten by the same rule do
+// not share the maps of the rule or of each other.
+func TestApplyDoesNotShareSchemas(t *testing.T) {
+	rules, err := Parse([]byte(`{"rules": [{"actions": [{"type": "addProperty", "property": "figmaUrl", "schema": {"type": "string"}}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools, _ := rules.Apply([]interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b"},
+	})
+	schema := func(tool interface{}) map[string]interface{} {
+		properties := tool.(map[string]interface{})["inputSchema"].(map[string]interface{})["properties"].(map[string]interface{})
+		return properties["figmaUrl"].(map[string]interface{})
+	}
+	schema(tools[0])["type"] = "changed"
+	if schema(tools[1])["type"] != "string" || rules.Rules[0].Actions[0].Schema["type"] != "string" {
+		t.Error("rewritten tools share their schema")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{name: "invalid JSON", rules: `{"rules": [`, want: "rules: unexpected EOF"},
+		{name: "unknown field", rules: `{"rules": [{"matches": {}, "actions": [{"type": "remove"}]}]}`, want: `unknown field "matches"`},
+		{name: "unknown action field", rules: `{"rules": [{"actions": [{"type": "remove", "tool": "x"}]}]}`, want: `unknown field "tool"`},
+		{name: "invalid tool pattern", rules: `{"rules": [{"match": {"tool": "get_["}, "actions": [{"type": "remove"}]}]}`, want: `rules: rule 1: invalid tool pattern "get_["`},
+		{name: "no actions", rules: `{"rules": [{"name": "empty", "match": {"tool": "get_code"}}]}`, want: "rules: empty: no actions"},
+		{name: "unknown action type", rules: `{"rules": [{"actions": [{"type": "hide"}]}]}`, want: "rules: rule 1: hide: unknown action type"},
+		{name: "addProperty without schema", rules: `{"rules": [{"actions": [{"type": "addProperty", "property": "figmaUrl"}]}]}`, want: "rules: rule 1: addProperty: property and schema are required"},
+		{name: "addProperty without property", rules: `{"rules": [{"actions": [{"type": "addProperty", "schema": {}}]}]}`, want: "rules: rule 1: addProperty: property and schema are required"},
+		{name: "require without properties", rules: `{"rules": [{"actions": [{"type": "require"}]}]}`, want: "rules: rule 1: require: properties are required"},
+		{name: "appendDescription without text", rules: `{"rules": [{"actions": [{"type": "appendDescription"}]}]}`, want: "rules: rule 1: appendDescription: text is required"},
+		{name: "replaceDescription without text", rules: `{"rules": [{"actions": [{"type": "replaceDescription"}]}]}`, want: "rules: rule 1: replaceDescription: text is required"},
+		{name: "rename without name", rules: `{"rules": [{"match": {"tool": "get_code"}, "actions": [{"type": "rename"}]}]}`, want: "rules: rule 1: rename: name is required"},
+		{name: "rename of every tool", rules: `{"rules": [{"actions": [{"type": "rename", "name": "x"}]}]}`, want: "rules: rule 1: rename: the rule must match a single tool by its exact name"},
+		{name: "rename of a pattern", rules: `{"rules": [{"match": {"tool": "get_*"}, "actions": [{"type": "rename", "name": "x"}]}]}`, want: "rules: rule 1: rename: the rule must match a single tool by its exact name"},
+		{name: "duplicate new name", rules: `{"rules": [{"match": {"tool": "a"}, "actions": [{"type": "rename", "name": "x"}]}, {"name": "second", "match": {"tool": "b"}, "actions": [{"type": "rename", "name": "x"}]}]}`, want: `rules: second: "x" is already the new name of "a"`},
+		{name: "setAnnotations without annotations", rules: `{"rules": [{"actions": [{"type": "setAnnotations"}]}]}`, want: "rules: rule 1: setAnnotations: annotations are required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.rules))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHidden(t *testing.T) {
+	rules, err := Parse([]byte(`{"rules": [
+		{"match": {"tool": "get_code"}, "actions": [{"type": "rename", "name": "figma_get_code"}]},
+		{"match": {"tool": "get_screenshot"}, "actions": [{"type": "remove"}]},
+		{"match": {"tool": "get_variable_*"}, "actions": [{"type": "remove"}]},
+		{"match": {"tool": "get_metadata", "hasProperty": "depth"}, "actions": [{"type": "remove"}]},
+		{"match": {"tool": "a"}, "actions": [{"type": "rename", "name": "b"}]},
+		{"match": {"tool": "b"}, "actions": [{"type": "rename", "name": "a"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		hidden bool
+	}{
+		{name: "get_code", hidden: true},
+		{name: "figma_get_code"},
+		{name: "get_screenshot", hidden: true},
+		// Only exact names are tracked
+		{name: "get_variable_defs"},
+		// The tool is only removed when it has the property
+		{name: "get_metadata"},
+		// Swapped names are both listed
+		{name: "a"},
+		{name: "b"},
+		{name: "create_design_system_rules"},
+	}
+	for _, tt := range tests {
+		if got := rules.Hidden(tt.name); got != tt.hidden {
+			t.Errorf("Hidden(%q) = %v, want %v", tt.name, got, tt.hidden)
+		}
+	}
+}
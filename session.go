@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// sessionDesigns remembers the current design of each MCP session, keyed by
+// Mcp-Session-Id, so that follow-up tool calls can omit fileKey and fileName.
+type sessionDesigns struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*sessionEntry
+}
+
+type sessionEntry struct {
+	design   util.Design
+	lastUsed time.Time
+}
+
+func newSessionDesigns(ttl time.Duration) *sessionDesigns {
+	return &sessionDesigns{ttl: ttl, entries: make(map[string]*sessionEntry)}
+}
+
+// current returns the session's current design.
+func (s *sessionDesigns) current(sessionID string) (util.Design, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[sessionID]
+	if !ok || time.Since(entry.lastUsed) > s.ttl {
+		return util.Design{}, false
+	}
+	entry.lastUsed = time.Now()
+	return entry.design, true
+}
+
+// set makes design the session's current design. The node is not remembered,
+// every tool call names its own node.
+func (s *sessionDesigns) set(sessionID string, design util.Design) {
+	if sessionID == "" {
+		return
+	}
+	design.NodeId = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Drop idle sessions so that clients that never terminate their session
+	// do not grow the map forever
+	for id, entry := range s.entries {
+		if now.Sub(entry.lastUsed) > s.ttl {
+			delete(s.entries, id)
+		}
+	}
+	s.entries[sessionID] = &sessionEntry{design: design, lastUsed: now}
+}
+
+// clear forgets the session's current design.
+func (s *sessionDesigns) clear(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, sessionID)
+}
+
+// hasDesignArguments reports whether a tool call names a design itself, even
+// partially.
+func hasDesignArguments(call *jsonrpc.ToolCallParams) bool {
+	for _, name := range []string{"figmaUrl", "fileKey", "fileName"} {
+		if _, exists := call.Arguments[name]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// applySessionDesign points a tool call without design arguments at the
+// session's current design by adding a figmaUrl argument. It reports whether
+// the message was changed. Tool calls that name a design themselves are left
+// alone, the handler records their design once it has been opened.
+func applySessionDesign(reqID string, sessions *sessionDesigns, sessionID string, msg *jsonrpc.Message) (bool, error) {
+	call, isCall, err := msg.ToolCall()
+	if !isCall || err != nil || sessionID == "" {
+		return false, nil
+	}
+
+	if hasDesignArguments(call) {
+		return false, nil
+	}
+
+	current, ok := sessions.current(sessionID)
+	if !ok {
+		log.Printf("[SESSION] [%s] Session %s has no current design", reqID, sessionID)
+		return false, nil
+	}
+	log.Printf("[SESSION] [%s] Using session %s current design %s for %s", reqID, sessionID, current, call.Name)
+	return true, msg.SetToolArgument("figmaUrl", current.WebURL())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// failingOpener fails to open the file keys in fail.
+type failingOpener struct {
+	util.RecordingOpener
+	fail map[string]bool
+}
+
+func (o *failingOpener) Open(ctx context.Context, design util.Design) error {
+	if o.fail[design.FileKey] {
+		return errors.New("cannot open " + design.FileKey)
+	}
+	return o.RecordingOpener.Open(ctx, design)
+}
+
+// TestSessionDesignRecordedAfterOpen checks that a tool call only becomes the
+// session's current design once it passed validation and the file scope
+// check, and its design was opened.
+func TestSessionDesignRecordedAfterOpen(t *testing.T) {
+	upstream := newJSONUpstream(t)
+	opener := &failingOpener{fail: map[string]bool{"brokenKey": true}}
+	handler := newMCPHandler(newTestConfig(t, upstream.URL, opener))
+	key := &keystore.Key{Name: "scoped", Scopes: keystore.Scopes{Files: []string{"goodKey", "brokenKey"}}}
+
+	call := func(id int, tool string, arguments map[string]interface{}) rpcResponse {
+		req := newToolCallRequest(id, tool, arguments)
+		req.Header.Set("Mcp-Session-Id", "session-1")
+		return serveRPC(t, handler, req.WithContext(withAPIKey(req.Context(), key)))
+	}
+	sessionFileKey := func() string {
+		resp := call(100, "get_active_design", nil)
+		var info struct {
+			Session *designInfo `json:"session"`
+		}
+		if resp.Result == nil {
+			t.Fatalf("get_active_design failed: %+v", resp.Error)
+		}
+		if err := json.Unmarshal(resp.Result.StructuredContent, &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Session == nil {
+			return ""
+		}
+		return info.Session.FileKey
+	}
+
+	if resp := call(1, "get_code", map[string]interface{}{"fileKey": "goodKey", "fileName": "Good"}); resp.Error != nil {
+		t.Fatalf("call for an allowed file failed: %+v", resp.Error)
+	}
+	if got := sessionFileKey(); got != "goodKey" {
+		t.Fatalf("session design = %q after a successful call, want goodKey", got)
+	}
+
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+		code      int
+	}{
+		{name: "invalid arguments", arguments: map[string]interface{}{"fileKey": "goodKey", "fileName": "bad name"}, code: jsonrpc.CodeInvalidParams},
+		{name: "file outside the key's scope", arguments: map[string]interface{}{"fileKey": "otherKey", "fileName": "Other"}, code: codeFileForbidden},
+		{name: "failed open", arguments: map[string]interface{}{"fileKey": "brokenKey", "fileName": "Broken"}, code: codeDesignOpenFailed},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(2+i, "get_code", tt.arguments)
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("response = %+v, want error %d", resp, tt.code)
+			}
+			if got := sessionFileKey(); got != "goodKey" {
+				t.Errorf("session design = %q after a rejected call, want goodKey", got)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,142 @@
+// Package sse reads server-sent event streams as used by MCP Streamable HTTP.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// maxLineSize bounds a single line of the stream. Figma tool results such as
+// get_code can be large, and every JSON-RPC message is sent on one data line.
+const maxLineSize = 64 * 1024 * 1024
+
+// Event is a single server-sent event.
+type Event struct {
+	// Event is the event type, empty for the default "message" type
+	Event string
+	// ID is the event id, used by clients to resume a stream
+	ID string
+	// HasID reports whether the event set an id field, which may be empty
+	HasID bool
+	// Retry is the raw value of the retry field
+	Retry string
+	// Data is the event data, with multiple data lines joined by "\n"
+	Data string
+	// Comments holds comment lines without their leading ":"
+	Comments []string
+	// Raw holds the bytes the event was read from, including its terminating
+	// blank line. WriteEvent writes them unchanged, so it must be cleared when
+	// the event is modified.
+	Raw []byte
+}
+
+// Reader parses events from a stream.
+type Reader struct {
+	scanner *bufio.Scanner
+	// eol is the line ending of the last scanned line, empty at the end of an
+	// unterminated stream
+	eol string
+}
+
+// NewReader returns a Reader reading events from r.
+func NewReader(r io.Reader) *Reader {
+	reader := &Reader{scanner: bufio.NewScanner(r)}
+	reader.scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	reader.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanLines(data, atEOF)
+		if token != nil {
+			reader.eol = string(data[len(token):advance])
+		}
+		return advance, token, err
+	})
+	return reader
+}
+
+// Next returns the next event in the stream. It returns io.EOF once the stream
+// ends. Unlike a browser, which discards an unterminated trailing event, Next
+// returns it so that no upstream message is lost when a server closes the
+// stream early. Its Raw bytes are then terminated as a complete event.
+func (r *Reader) Next() (*Event, error) {
+	var event Event
+	var data []string
+	var raw bytes.Buffer
+	hasFields := false
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			if !hasFields {
+				continue
+			}
+			raw.WriteString(r.eol)
+			event.Data = strings.Join(data, "\n")
+			event.Raw = raw.Bytes()
+			return &event, nil
+		}
+		hasFields = true
+		raw.WriteString(line)
+		raw.WriteString(r.eol)
+
+		if strings.HasPrefix(line, ":") {
+			event.Comments = append(event.Comments, line[1:])
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				event.ID = value
+				event.HasID = true
+			}
+		case "retry":
+			event.Retry = value
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if hasFields {
+		if r.eol == "" {
+			raw.WriteString("\n")
+		}
+		raw.WriteString("\n")
+		event.Data = strings.Join(data, "\n")
+		event.Raw = raw.Bytes()
+		return &event, nil
+	}
+	return nil, io.EOF
+}
+
+// scanLines splits on "\n", "\r\n" and a lone "\r", all of which end a line in
+// an event stream.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for i, b := range data {
+		switch b {
+		case '\n':
+			return i + 1, data[:i], nil
+		case '\r':
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if atEOF {
+				return i + 1, data[:i], nil
+			}
+			// Need more data to know whether "\r\n" follows
+			return 0, nil, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,179 @@
+package sse
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, stream string) []*Event {
+	t.Helper()
+	reader := NewReader(strings.NewReader(stream))
+	var events []*Event
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []Event
+	}{
+		{
+			name:   "single data line",
+			stream: "event: message\ndata: {\"id\":1}\n\n",
+			want:   []Event{{Event: "message", Data: `{"id":1}`}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata:second\ndata:  indented\ndata\n\n",
+			want:   []Event{{Data: "first\nsecond\n indented\n"}},
+		},
+		{
+			name:   "CRLF line endings",
+			stream: "event: message\r\ndata: a\r\ndata: b\r\n\r\ndata: c\r\n\r\n",
+			want:   []Event{{Event: "message", Data: "a\nb"}, {Data: "c"}},
+		},
+		{
+			name:   "lone CR line endings",
+			stream: "event: message\rdata: a\rdata: b\r\rdata: c\r\r",
+			want:   []Event{{Event: "message", Data: "a\nb"}, {Data: "c"}},
+		},
+		{
+			name:   "id",
+			stream: "id: 42\ndata: x\n\n",
+			want:   []Event{{ID: "42", HasID: true, Data: "x"}},
+		},
+		{
+			name:   "empty id",
+			stream: "id\ndata: x\n\nid:\ndata: y\n\n",
+			want:   []Event{{HasID: true, Data: "x"}, {HasID: true, Data: "y"}},
+		},
+		{
+			name:   "id with NUL is ignored",
+			stream: "id: a\x00b\ndata: x\n\n",
+			want:   []Event{{Data: "x"}},
+		},
+		{
+			name:   "retry",
+			stream: "retry: 3000\ndata: x\n\n",
+			want:   []Event{{Retry: "3000", Data: "x"}},
+		},
+		{
+			name:   "comments",
+			stream: ": keep-alive\n\n:ping\ndata: x\n\n",
+			want:   []Event{{Comments: []string{" keep-alive"}}, {Comments: []string{"ping"}, Data: "x"}},
+		},
+		{
+			name:   "unknown fields",
+			stream: "foo: bar\ndata: x\n\n",
+			want:   []Event{{Data: "x"}},
+		},
+		{
+			name:   "leading blank lines",
+			stream: "\n\n\ndata: x\n\n",
+			want:   []Event{{Data: "x"}},
+		},
+		{
+			name:   "unterminated final event",
+			stream: "data: a\n\ndata: b\ndata: c",
+			want:   []Event{{Data: "a"}, {Data: "b\nc"}},
+		},
+		{
+			name:   "final event without blank line",
+			stream: "data: a\n",
+			want:   []Event{{Data: "a"}},
+		},
+		{
+			name:   "empty stream",
+			stream: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := readAll(t, tt.stream)
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(events), len(tt.want), events)
+			}
+			for i, event := range events {
+				got := *event
+				got.Raw = nil
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("event %d = %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestReaderRaw checks that the raw bytes of the events make up the stream,
+// with an unterminated final event completed.
+func TestReaderRaw(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []string
+	}{
+		{
+			name:   "mixed line endings",
+			stream: "retry: 10\r\nid: 7\revent: message\ndata: a\r\ndata: b\r\n\r\n: ping\n\n",
+			want:   []string{"retry: 10\r\nid: 7\revent: message\ndata: a\r\ndata: b\r\n\r\n", ": ping\n\n"},
+		},
+		{
+			name:   "unknown fields keep their place",
+			stream: "data: x\nfoo: bar\nid: 1\n\n",
+			want:   []string{"data: x\nfoo: bar\nid: 1\n\n"},
+		},
+		{
+			name:   "leading blank lines are dropped",
+			stream: "\r\n\ndata: x\n\n",
+			want:   []string{"data: x\n\n"},
+		},
+		{
+			name:   "unterminated final line",
+			stream: "data: a\n\ndata: b",
+			want:   []string{"data: a\n\n", "data: b\n\n"},
+		},
+		{
+			name:   "missing final blank line",
+			stream: "data: a\r\n",
+			want:   []string{"data: a\r\n\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, event := range readAll(t, tt.stream) {
+				got = append(got, string(event.Raw))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("raw events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReaderLongLine reads a line that, with its "\r\n" ending, just fits
+// the scanner buffer of maxLineSize bytes, and one that does not.
+func TestReaderLongLine(t *testing.T) {
+	data := strings.Repeat("x", maxLineSize-len("data: \r\n"))
+	events := readAll(t, "data: "+data+"\r\n\r\n")
+	if len(events) != 1 || events[0].Data != data {
+		t.Fatalf("a line of maxLineSize bytes was not read whole")
+	}
+
+	reader := NewReader(strings.NewReader("data: " + data + "xx\n\n"))
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("a line over maxLineSize gave %v, want an error", err)
+	}
+}
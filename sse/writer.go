@@ -0,0 +1,86 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// WriteEvent writes a single event, terminated by a blank line. An event read
+// from a stream is written back as its Raw bytes; otherwise data containing
+// newlines is split across several data lines.
+func WriteEvent(w io.Writer, event *Event) error {
+	if event.Raw != nil {
+		_, err := w.Write(event.Raw)
+		return err
+	}
+	var b strings.Builder
+	for _, comment := range event.Comments {
+		b.WriteString(":" + comment + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.HasID {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Retry != "" {
+		b.WriteString("retry: " + event.Retry + "\n")
+	}
+	if event.Data != "" {
+		for _, line := range strings.Split(event.Data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// TransformFunc rewrites a single event. Returning nil drops the event. A
+// function that changes the event must clear its Raw bytes.
+type TransformFunc func(event *Event) *Event
+
+// Transform copies the event stream from src to dst, passing every event through
+// fn. Each event is written with a single Write call as soon as it has been read,
+// so a flushing writer forwards the stream incrementally.
+func Transform(dst io.Writer, src io.Reader, fn TransformFunc) error {
+	reader := NewReader(src)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event = fn(event); event == nil {
+			continue
+		}
+		if err := WriteEvent(dst, event); err != nil {
+			return err
+		}
+	}
+}
+
+// NewTransformReader returns a ReadCloser that streams src rewritten by fn.
+// Closing it closes src and stops the transformation.
+func NewTransformReader(src io.ReadCloser, fn TransformFunc) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		err := Transform(pw, bufio.NewReader(src), fn)
+		src.Close()
+		pw.CloseWithError(err)
+	}()
+	return &transformReader{PipeReader: pr, src: src}
+}
+
+type transformReader struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+func (t *transformReader) Close() error {
+	t.PipeReader.Close()
+	return t.src.Close()
+}
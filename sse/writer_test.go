@@ -0,0 +1,103 @@
+package sse
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			name:  "message",
+			event: Event{Event: "message", Data: `{"id":1}`},
+			want:  "event: message\ndata: {\"id\":1}\n\n",
+		},
+		{
+			name:  "multi-line data",
+			event: Event{Data: "a\nb"},
+			want:  "data: a\ndata: b\n\n",
+		},
+		{
+			name:  "all fields",
+			event: Event{Comments: []string{" ping"}, Event: "message", ID: "7", HasID: true, Retry: "10", Data: "x"},
+			want:  ": ping\nevent: message\nid: 7\nretry: 10\ndata: x\n\n",
+		},
+		{
+			name:  "empty id",
+			event: Event{HasID: true, Data: "x"},
+			want:  "id: \ndata: x\n\n",
+		},
+		{
+			name:  "raw bytes win",
+			event: Event{Data: "ignored", Raw: []byte("data: raw\r\n\r\n")},
+			want:  "data: raw\r\n\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := WriteEvent(&b, &tt.event); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("WriteEvent = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestTransformKeepsUntouchedEvents checks that only rewritten events are
+// re-serialized, and that the other events keep their bytes.
+func TestTransformKeepsUntouchedEvents(t *testing.T) {
+	stream := "retry: 3000\r\nid: 1\r\nfoo: bar\r\ndata: keep\r\n\r\n" +
+		"id: 2\nevent: message\ndata: rewrite\n\n" +
+		": ping\n\n" +
+		"data: drop\n\n" +
+		"data: last"
+	var out strings.Builder
+	err := Transform(&out, strings.NewReader(stream), func(event *Event) *Event {
+		switch event.Data {
+		case "rewrite":
+			event.Data = "rewritten\nagain"
+			event.Raw = nil
+		case "drop":
+			return nil
+		}
+		return event
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "retry: 3000\r\nid: 1\r\nfoo: bar\r\ndata: keep\r\n\r\n" +
+		"event: message\nid: 2\ndata: rewritten\ndata: again\n\n" +
+		": ping\n\n" +
+		"data: last\n\n"
+	if out.String() != want {
+		t.Errorf("Transform = %q, want %q", out.String(), want)
+	}
+}
+
+func TestTransformReader(t *testing.T) {
+	src := io.NopCloser(strings.NewReader("data: a\n\ndata: b\n\n"))
+	reader := NewTransformReader(src, func(event *Event) *Event {
+		if event.Data == "b" {
+			return nil
+		}
+		return event
+	})
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "data: a\n\n" {
+		t.Errorf("body = %q, want the first event only", body)
+	}
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"net/url"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+)
+
+// Design identifies a Figma document to open. The zero Design opens the Figma
+// application without a document.
+type Design struct {
+	// Type is the kind of document, an empty Type is a Design file
+	Type     figmaurl.LinkType
+	FileKey  string
+	FileName string
+	// BranchKey selects a branch of the file
+	BranchKey string
+	NodeId    string
+}
+
+// DesignFromLink returns the design a parsed Figma link points to.
+func DesignFromLink(link *figmaurl.Link) Design {
+	return Design{
+		Type:      link.Type,
+		FileKey:   link.FileKey,
+		FileName:  link.FileName,
+		BranchKey: link.BranchKey,
+		NodeId:    link.NodeId,
+	}
+}
+
+// LinkType returns the kind of document, defaulting to a Design file.
+func (d Design) LinkType() figmaurl.LinkType {
+	if d.Type == "" {
+		return figmaurl.Design
+	}
+	return d.Type
+}
+
+// SameDocument reports whether both designs refer to the same document, which
+// is the same file, branch and link type regardless of node.
+func (d Design) SameDocument(other Design) bool {
+	return d.LinkType() == other.LinkType() && d.FileKey == other.FileKey && d.BranchKey == other.BranchKey
+}
+
+// URL returns the figma:// deep link for the design, with every component
+// URL-encoded:
+//
+//	figma://design/{fileKey}/{fileName}?node-id={nodeId}
+//	figma://design/{fileKey}/branch/{branchKey}/{fileName}?node-id={nodeId}
+//	figma://proto/{fileKey}/{fileName}?node-id={nodeId}
+//	figma://board/{fileKey}/{fileName}?node-id={nodeId}
+//	figma://slides/{fileKey}/{fileName}?node-id={nodeId}
+//	figma://make/{fileKey}/{fileName}
+func (d Design) URL() string {
+	if d.FileKey == "" {
+		return "figma://"
+	}
+	figmaURL := "figma://" + string(d.LinkType()) + "/" + url.PathEscape(d.FileKey)
+	if d.BranchKey != "" {
+		figmaURL += "/branch/" + url.PathEscape(d.BranchKey)
+	}
+	if d.FileName != "" {
+		figmaURL += "/" + escapeFileName(d.FileName)
+	}
+	if d.NodeId != "" && d.LinkType() != figmaurl.Make {
+		figmaURL += "?" + url.Values{"node-id": {escapeColonsForFigma(d.NodeId)}}.Encode()
+	}
+	return figmaURL
+}
+
+// escapeFileName path-escapes a file slug, keeping slugs that are already
+// percent-encoded as they are.
+func escapeFileName(fileName string) string {
+	if fileNamePattern.MatchString(fileName) {
+		return fileName
+	}
+	return url.PathEscape(fileName)
+}
+
+func (d Design) String() string {
+	return d.URL()
+}
+
+// WebURL returns the https://www.figma.com link for the design, which is the
+// form people share and figmaurl.Parse accepts.
+func (d Design) WebURL() string {
+	webURL := "https://www.figma.com/" + string(d.LinkType()) + "/" + url.PathEscape(d.FileKey)
+	if d.BranchKey != "" {
+		webURL += "/branch/" + url.PathEscape(d.BranchKey)
+	}
+	if d.FileName != "" {
+		webURL += "/" + escapeFileName(d.FileName)
+	}
+	if d.NodeId != "" {
+		webURL += "?" + url.Values{"node-id": {escapeColonsForFigma(d.NodeId)}}.Encode()
+	}
+	return webURL
+}
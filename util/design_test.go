@@ -0,0 +1,118 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+)
+
+func TestDesignFromLinkURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want Design
+		deep string
+		web  string
+	}{
+		{
+			name: "design",
+			url:  "https://www.figma.com/design/abc123/My-File?node-id=1-2",
+			want: Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+			deep: "figma://design/abc123/My-File?node-id=1-2",
+			web:  "https://www.figma.com/design/abc123/My-File?node-id=1-2",
+		},
+		{
+			name: "legacy file",
+			url:  "https://www.figma.com/file/abc123/My-File?node-id=1-2",
+			want: Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+			deep: "figma://design/abc123/My-File?node-id=1-2",
+			web:  "https://www.figma.com/design/abc123/My-File?node-id=1-2",
+		},
+		{
+			name: "branch",
+			url:  "https://www.figma.com/design/abc123/branch/def456/My-File?node-id=3-4",
+			want: Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", BranchKey: "def456", NodeId: "3:4"},
+			deep: "figma://design/abc123/branch/def456/My-File?node-id=3-4",
+			web:  "https://www.figma.com/design/abc123/branch/def456/My-File?node-id=3-4",
+		},
+		{
+			name: "proto",
+			url:  "https://www.figma.com/proto/abc123/My-Prototype?node-id=5-6",
+			want: Design{Type: figmaurl.Proto, FileKey: "abc123", FileName: "My-Prototype", NodeId: "5:6"},
+			deep: "figma://proto/abc123/My-Prototype?node-id=5-6",
+			web:  "https://www.figma.com/proto/abc123/My-Prototype?node-id=5-6",
+		},
+		{
+			name: "board",
+			url:  "https://www.figma.com/board/abc123/My-Board?node-id=0-1",
+			want: Design{Type: figmaurl.Board, FileKey: "abc123", FileName: "My-Board", NodeId: "0:1"},
+			deep: "figma://board/abc123/My-Board?node-id=0-1",
+			web:  "https://www.figma.com/board/abc123/My-Board?node-id=0-1",
+		},
+		{
+			name: "slides",
+			url:  "https://www.figma.com/slides/abc123/My-Deck?node-id=7-8",
+			want: Design{Type: figmaurl.Slides, FileKey: "abc123", FileName: "My-Deck", NodeId: "7:8"},
+			deep: "figma://slides/abc123/My-Deck?node-id=7-8",
+			web:  "https://www.figma.com/slides/abc123/My-Deck?node-id=7-8",
+		},
+		{
+			name: "make drops the node",
+			url:  "https://www.figma.com/make/abc123/My-App?node-id=0-1",
+			want: Design{Type: figmaurl.Make, FileKey: "abc123", FileName: "My-App", NodeId: "0:1"},
+			deep: "figma://make/abc123/My-App",
+			web:  "https://www.figma.com/make/abc123/My-App?node-id=0-1",
+		},
+		{
+			name: "embed",
+			url:  "https://embed.figma.com/embed?embed_host=share&url=https%3A%2F%2Fwww.figma.com%2Fdesign%2Fabc123%2FMy-File%3Fnode-id%3D1-2",
+			want: Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"},
+			deep: "figma://design/abc123/My-File?node-id=1-2",
+			web:  "https://www.figma.com/design/abc123/My-File?node-id=1-2",
+		},
+		{
+			name: "percent-encoded file name",
+			url:  "https://www.figma.com/design/abc123/My%20File",
+			want: Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My%20File"},
+			deep: "figma://design/abc123/My%20File",
+			web:  "https://www.figma.com/design/abc123/My%20File",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := figmaurl.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.url, err)
+			}
+			design := DesignFromLink(link)
+			if design != tt.want {
+				t.Errorf("DesignFromLink() = %+v, want %+v", design, tt.want)
+			}
+			if got := design.URL(); got != tt.deep {
+				t.Errorf("URL() = %q, want %q", got, tt.deep)
+			}
+			if got := design.WebURL(); got != tt.web {
+				t.Errorf("WebURL() = %q, want %q", got, tt.web)
+			}
+		})
+	}
+}
+
+func TestDesignURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		design Design
+		want   string
+	}{
+		{name: "zero design opens the application", design: Design{}, want: "figma://"},
+		{name: "empty type is a design file", design: Design{FileKey: "abc123", FileName: "My-File"}, want: "figma://design/abc123/My-File"},
+		{name: "file name is escaped", design: Design{FileKey: "abc123", FileName: "My File"}, want: "figma://design/abc123/My%20File"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.design.URL(); got != tt.want {
+				t.Errorf("URL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Opener switches Figma desktop to a design.
+type Opener interface {
+	Open(ctx context.Context, design Design) error
+}
+
+// CommandOpener runs a custom command to open designs. Each argument is a
+// text/template rendered with the Design and its URL, for example
+// []string{"my-automation", "open", "{{.URL}}"}. The command is executed
+// directly, never through a shell.
+type CommandOpener struct {
+	args []*template.Template
+}
+
+// NewCommandOpener parses the templated command arguments.
+func NewCommandOpener(args []string) (*CommandOpener, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command opener requires a command")
+	}
+	o := &CommandOpener{}
+	for i, arg := range args {
+		tmpl, err := template.New(fmt.Sprintf("arg%d", i)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parse command argument %q: %w", arg, err)
+		}
+		o.args = append(o.args, tmpl)
+	}
+	return o, nil
+}
+
+// Open implements Opener.
+func (o *CommandOpener) Open(ctx context.Context, design Design) error {
+	if err := design.Validate(); err != nil {
+		return err
+	}
+	data := struct {
+		Design
+		URL string
+	}{design, design.URL()}
+
+	args := make([]string, 0, len(o.args))
+	for _, tmpl := range o.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("render command argument: %w", err)
+		}
+		args = append(args, buf.String())
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	log.Printf("[UTIL] Executing custom open command: %s %v", cmd.Path, cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("[UTIL] ERROR: Custom open command failed: %v, output: %s", err, strings.TrimSpace(string(out)))
+		return fmt.Errorf("failed to open %s: %v", design, err)
+	}
+	log.Printf("[UTIL] Custom open command executed successfully")
+	return nil
+}
+
+// HTTPOpener asks a local agent to open designs by POSTing a JSON body of the
+// form {"url": "figma://...", "type": "design", "fileKey": "...", "fileName": "...",
+// "branchKey": "...", "nodeId": "..."}.
+// Any 2xx response is treated as success.
+type HTTPOpener struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// Open implements Opener.
+func (o *HTTPOpener) Open(ctx context.Context, design Design) error {
+	if err := design.Validate(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{
+		"url":       design.URL(),
+		"type":      string(design.LinkType()),
+		"fileKey":   design.FileKey,
+		"fileName":  design.FileName,
+		"branchKey": design.BranchKey,
+		"nodeId":    design.NodeId,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	log.Printf("[UTIL] POSTing %s to open callback %s", design, o.Endpoint)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", design, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to open %s: callback returned status %d", design, resp.StatusCode)
+	}
+	log.Printf("[UTIL] Open callback succeeded with status %d", resp.StatusCode)
+	return nil
+}
+
+// NoopOpener opens nothing, for running the proxy headless. It only logs the
+// designs it is asked to open.
+type NoopOpener struct{}
+
+// Open implements Opener.
+func (NoopOpener) Open(ctx context.Context, design Design) error {
+	log.Printf("[UTIL] Not opening %s, no-op opener configured", design)
+	return nil
+}
+
+// RecordingOpener records every design it is asked to open without launching
+// anything, for tests. It keeps every design, so it is not meant for
+// long-running proxies.
+type RecordingOpener struct {
+	// Err, when set, is returned from every Open call
+	Err error
+
+	mu      sync.Mutex
+	designs []Design
+}
+
+// Open implements Opener.
+func (o *RecordingOpener) Open(ctx context.Context, design Design) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	log.Printf("[UTIL] Recording open of %s", design)
+	o.designs = append(o.designs, design)
+	return o.Err
+}
+
+// Designs returns the designs opened so far, in order.
+func (o *RecordingOpener) Designs() []Design {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Design(nil), o.designs...)
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+)
+
+var testDesign = Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"}
+
+// TestHelperProcess is run by the command opener tests as the open command. It
+// writes its arguments, one per line, to the file named by OPENER_ARGS_FILE.
+func TestHelperProcess(t *testing.T) {
+	file := os.Getenv("OPENER_ARGS_FILE")
+	if file == "" {
+		return
+	}
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(args, "\n")), 0o600); err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func TestCommandOpener(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "args")
+	t.Setenv("OPENER_ARGS_FILE", file)
+
+	opener, err := NewCommandOpener([]string{
+		os.Args[0], "-test.run=TestHelperProcess", "--",
+		"{{.URL}}", "{{.FileKey}}/{{.NodeId}}",
+		// Executed without a shell, these stay literal
+		"$(touch pwned); {{.FileName}}", "*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opener.Open(context.Background(), testDesign); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"figma://design/abc123/My-File?node-id=1-2", "abc123/1:2", "$(touch pwned); My-File", "*"}
+	if got := strings.Split(string(data), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("command arguments = %q, want %q", got, want)
+	}
+	if _, err := os.Stat("pwned"); err == nil {
+		os.Remove("pwned")
+		t.Error("an argument was run through a shell")
+	}
+}
+
+func TestCommandOpenerErrors(t *testing.T) {
+	if _, err := NewCommandOpener(nil); err == nil {
+		t.Error("NewCommandOpener accepted an empty command")
+	}
+	if _, err := NewCommandOpener([]string{"open", "{{.URL"}); err == nil {
+		t.Error("NewCommandOpener accepted an invalid template")
+	}
+
+	// A misspelled field fails the open instead of rendering as "<no value>"
+	opener, err := NewCommandOpener([]string{"true", "{{.FileKeys}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opener.Open(context.Background(), testDesign); err == nil || !strings.Contains(err.Error(), "render command argument") {
+		t.Errorf("Open with an unknown field = %v, want a render error", err)
+	}
+
+	opener, err = NewCommandOpener([]string{"false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opener.Open(context.Background(), testDesign); err == nil {
+		t.Error("Open succeeded although the command failed")
+	}
+	if err := opener.Open(context.Background(), Design{}); err == nil {
+		t.Error("Open accepted a design without a file key")
+	}
+}
+
+func TestHTTPOpener(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "redirect", status: http.StatusNotModified, wantErr: true},
+		{name: "client error", status: http.StatusNotFound, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]string
+			var contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					t.Errorf("method = %s, want POST", r.Method)
+				}
+				contentType = r.Header.Get("Content-Type")
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("invalid body: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			opener := &HTTPOpener{Endpoint: server.URL + "/open"}
+			err := opener.Open(context.Background(), testDesign)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open error = %v, want error %v", err, tt.wantErr)
+			}
+			if contentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", contentType)
+			}
+			want := map[string]string{
+				"url":       "figma://design/abc123/My-File?node-id=1-2",
+				"type":      "design",
+				"fileKey":   "abc123",
+				"fileName":  "My-File",
+				"branchKey": "",
+				"nodeId":    "1:2",
+			}
+			if !reflect.DeepEqual(body, want) {
+				t.Errorf("body = %v, want %v", body, want)
+			}
+		})
+	}
+}
+
+func TestHTTPOpenerUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	opener := &HTTPOpener{Endpoint: server.URL}
+	if err := opener.Open(context.Background(), testDesign); err == nil {
+		t.Error("Open succeeded without a callback server")
+	}
+}
@@ -0,0 +1,262 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe checks whether Figma desktop is ready to serve tool calls for a design.
+// It returns nil once the design is active.
+type Probe interface {
+	Probe(ctx context.Context, fileKey, nodeId string) error
+}
+
+// ReadinessWaiter polls a Probe until Figma desktop reports the requested design
+// is active or the timeout passes.
+type ReadinessWaiter struct {
+	Probe    Probe
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// ErrNotReady is wrapped by the error returned from Wait when the deadline passes
+// before the probe succeeds.
+var ErrNotReady = errors.New("figma design not ready")
+
+// Wait blocks until the probe succeeds for the design, the timeout passes or ctx
+// is cancelled. An empty nodeId only waits for the Figma MCP server to respond.
+func (w *ReadinessWaiter) Wait(ctx context.Context, fileKey, nodeId string) error {
+	log.Printf("[UTIL] Waiting up to %v for Figma design %s (node %q) to become ready", w.Timeout, fileKey, nodeId)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	attempts := 0
+	for {
+		attempts++
+		err := w.Probe.Probe(ctx, fileKey, nodeId)
+		if err == nil {
+			log.Printf("[UTIL] Figma design %s ready after %v (%d probes)", fileKey, time.Since(start), attempts)
+			return nil
+		}
+		log.Printf("[UTIL] Probe %d for Figma design %s not ready: %v", attempts, fileKey, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: design %s (node %q) not active after %v and %d probes, last error: %v", ErrNotReady, fileKey, nodeId, time.Since(start).Round(time.Millisecond), attempts, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// MCPProbe probes the upstream Figma MCP server with a lightweight tool call
+// against the requested node. Figma answers with an error result until a file
+// containing the node is the active file. Node ids such as 0:1 exist in every
+// file, so with VerifyFile the result must also name the requested file.
+type MCPProbe struct {
+	// Endpoint is the URL of the upstream MCP endpoint, e.g. http://localhost:3845/mcp
+	Endpoint string
+	// Tool is the tool called to probe for the node, e.g. get_metadata
+	Tool   string
+	Client *http.Client
+	// VerifyFile requires the file key in the probe result. Without it an
+	// empty nodeId is ready as soon as the server responds.
+	VerifyFile bool
+
+	mu        sync.Mutex
+	sessionID string
+	nextID    int
+}
+
+// Probe implements Probe.
+func (p *MCPProbe) Probe(ctx context.Context, fileKey, nodeId string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sessionID == "" {
+		if err := p.initialize(ctx); err != nil {
+			return fmt.Errorf("initialize probe session: %w", err)
+		}
+	}
+	if nodeId == "" && !p.VerifyFile {
+		return nil
+	}
+
+	arguments := map[string]interface{}{}
+	if nodeId != "" {
+		arguments["nodeId"] = nodeId
+	}
+	result, err := p.call(ctx, "tools/call", map[string]interface{}{
+		"name":      p.Tool,
+		"arguments": arguments,
+	})
+	if err != nil {
+		// The session may have expired upstream, start a new one on the next probe
+		p.sessionID = ""
+		return err
+	}
+	if isError, _ := result["isError"].(bool); isError {
+		return fmt.Errorf("%s returned an error result for node %q", p.Tool, nodeId)
+	}
+	if p.VerifyFile && !mentionsFileKey(result, fileKey) {
+		return fmt.Errorf("%s result does not identify file %s as the active file", p.Tool, fileKey)
+	}
+	return nil
+}
+
+// mentionsFileKey reports whether a tool result contains the file key as a
+// whole word, in its text content or anywhere else.
+func mentionsFileKey(result map[string]interface{}, fileKey string) bool {
+	if fileKey == "" {
+		return false
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return false
+	}
+	text := string(data)
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], fileKey)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(fileKey)
+		if (start == 0 || !isKeyChar(text[start-1])) && (end == len(text) || !isKeyChar(text[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *MCPProbe) initialize(ctx context.Context) error {
+	p.sessionID = ""
+	_, err := p.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": "2025-06-18",
+		"capabilities":    map[string]interface{}{},
+		"clientInfo": map[string]interface{}{
+			"name":    "figma-mcp-proxy-probe",
+			"version": "1.0.0",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return p.notify(ctx, "notifications/initialized")
+}
+
+func (p *MCPProbe) post(ctx context.Context, message map[string]interface{}) (*http.Response, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if p.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", p.sessionID)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (p *MCPProbe) notify(ctx context.Context, method string) error {
+	resp, err := p.post(ctx, map[string]interface{}{"jsonrpc": "2.0", "method": method})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", method, resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *MCPProbe) call(ctx context.Context, method string, params map[string]interface{}) (map[string]interface{}, error) {
+	p.nextID++
+	resp, err := p.post(ctx, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      p.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%s returned status %d", method, resp.StatusCode)
+	}
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		p.sessionID = sessionID
+	}
+
+	payload, err := readRPCPayload(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	var message struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("%s: decode response: %w", method, err)
+	}
+	if message.Error != nil {
+		return nil, fmt.Errorf("%s returned error %d: %s", method, message.Error.Code, message.Error.Message)
+	}
+	return message.Result, nil
+}
+
+// readRPCPayload returns the JSON-RPC message from a JSON or SSE response body.
+func readRPCPayload(resp *http.Response) ([]byte, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return io.ReadAll(resp.Body)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" && len(data) > 0 {
+			return []byte(strings.Join(data, "\n")), nil
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		return []byte(strings.Join(data, "\n")), nil
+	}
+	return nil, errors.New("no data in event stream")
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newProbeServer returns an upstream MCP server whose probe tool answers with
+// text. It records the arguments of every tools/call.
+func newProbeServer(t *testing.T, text func() string, calls *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			ID     *int   `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid probe request: %v", err)
+		}
+		switch msg.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "probe-session")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":{}}`, *msg.ID)
+		case "notifications/initialized":
+			w.WriteHeader(http.StatusAccepted)
+		case "tools/call":
+			*calls = append(*calls, msg.Params.Arguments)
+			result, _ := json.Marshal(map[string]interface{}{
+				"content": []map[string]string{{"type": "text", "text": text()}},
+			})
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%d,\"result\":%s}\n\n", *msg.ID, result)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getMetadataText is the shape of a Figma get_metadata result: the layer XML
+// of the node, without the file key.
+const getMetadataText = `<frame id="0:1" name="Page 1" x="0" y="0" width="1440" height="900">` +
+	`<text id="1:2" name="Title" x="24" y="24" width="320" height="40" /></frame>`
+
+// TestMCPProbeVerifiesFileKey uses a probe tool that reports the active file,
+// as READY_VERIFY_FILE requires.
+func TestMCPProbeVerifiesFileKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		fileKey string
+		nodeId  string
+		ready   bool
+	}{
+		{"requested file", `{"fileKey":"abcDEF123","nodeId":"0:1"}`, "abcDEF123", "0:1", true},
+		{"other file with the same node", `{"fileKey":"zzzOther9","nodeId":"0:1"}`, "abcDEF123", "0:1", false},
+		{"file key as part of a longer key", `{"fileKey":"abcDEF1234","nodeId":"0:1"}`, "abcDEF123", "0:1", false},
+		{"result without a file key", getMetadataText, "abcDEF123", "0:1", false},
+		{"no node", `{"fileKey":"abcDEF123"}`, "abcDEF123", "", true},
+		{"no node and other file", `{"fileKey":"zzzOther9"}`, "abcDEF123", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []map[string]interface{}
+			server := newProbeServer(t, func() string { return tt.text }, &calls)
+			probe := &MCPProbe{Endpoint: server.URL, Tool: "get_active_file", VerifyFile: true}
+
+			err := probe.Probe(context.Background(), tt.fileKey, tt.nodeId)
+			if ready := err == nil; ready != tt.ready {
+				t.Fatalf("Probe() error = %v, want ready %v", err, tt.ready)
+			}
+			if len(calls) != 1 {
+				t.Fatalf("probe tool called %d times, want 1", len(calls))
+			}
+			if got, _ := calls[0]["nodeId"].(string); got != tt.nodeId {
+				t.Errorf("probe called with nodeId %q, want %q", got, tt.nodeId)
+			}
+		})
+	}
+}
+
+func TestMCPProbeWithoutVerifyFile(t *testing.T) {
+	var calls []map[string]interface{}
+	server := newProbeServer(t, func() string { return getMetadataText }, &calls)
+	probe := &MCPProbe{Endpoint: server.URL, Tool: "get_metadata"}
+
+	if err := probe.Probe(context.Background(), "abcDEF123", "1:2"); err != nil {
+		t.Fatalf("Probe() with a node = %v, want ready", err)
+	}
+	if err := probe.Probe(context.Background(), "abcDEF123", ""); err != nil {
+		t.Fatalf("Probe() without a node = %v, want ready", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("probe tool called %d times, want 1", len(calls))
+	}
+}
+
+func TestReadinessWaiterWaitsForFile(t *testing.T) {
+	var calls []map[string]interface{}
+	activeAfter := time.Now().Add(100 * time.Millisecond)
+	server := newProbeServer(t, func() string {
+		if time.Now().Before(activeAfter) {
+			return `{"fileKey":"zzzOther9"}`
+		}
+		return `{"fileKey":"abcDEF123"}`
+	}, &calls)
+	waiter := &ReadinessWaiter{
+		Probe:    &MCPProbe{Endpoint: server.URL, Tool: "get_active_file", VerifyFile: true},
+		Interval: 20 * time.Millisecond,
+		Timeout:  2 * time.Second,
+	}
+	if err := waiter.Wait(context.Background(), "abcDEF123", "0:1"); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if len(calls) < 2 {
+		t.Errorf("Wait() returned after %d probes, want it to wait for the file", len(calls))
+	}
+
+	waiter.Timeout = 100 * time.Millisecond
+	err := waiter.Wait(context.Background(), "neverOpen1", "0:1")
+	if !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Wait() for a file that never opens = %v, want a not ready error", err)
+	}
+}
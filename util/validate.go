@@ -0,0 +1,85 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+)
+
+var (
+	// Figma file keys are alphanumeric
+	fileKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,128}$`)
+	// File names are the URL slug of the file, made of unreserved URL
+	// characters and percent-encoded bytes
+	fileNamePattern = regexp.MustCompile(`^(?:[A-Za-z0-9._~-]|%[0-9A-Fa-f]{2})+$`)
+	// Node IDs are written 1:2 in the API and 1-2 in URLs
+	nodeIdPattern = regexp.MustCompile(`^[0-9]{1,10}[:-][0-9]{1,10}$`)
+)
+
+const maxFileNameLength = 512
+
+// ValidationError reports a design argument that is not safe to open.
+type ValidationError struct {
+	Field string
+	Value string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q", e.Field, e.Value)
+}
+
+// ValidateFileKey checks that fileKey only uses the Figma file key alphabet.
+func ValidateFileKey(fileKey string) error {
+	if !fileKeyPattern.MatchString(fileKey) {
+		return &ValidationError{Field: "fileKey", Value: fileKey}
+	}
+	return nil
+}
+
+// ValidateFileName checks that fileName is a URL-safe file slug.
+func ValidateFileName(fileName string) error {
+	if len(fileName) > maxFileNameLength || !fileNamePattern.MatchString(fileName) {
+		return &ValidationError{Field: "fileName", Value: fileName}
+	}
+	return nil
+}
+
+// ValidateNodeId checks that nodeId is of the form N:N or N-N.
+func ValidateNodeId(nodeId string) error {
+	if !nodeIdPattern.MatchString(nodeId) {
+		return &ValidationError{Field: "nodeId", Value: nodeId}
+	}
+	return nil
+}
+
+// Validate checks every field of the design. The zero Design, which opens the
+// Figma application, is valid, and FileName and NodeId are optional.
+func (d Design) Validate() error {
+	if d == (Design{}) {
+		return nil
+	}
+	switch d.LinkType() {
+	case figmaurl.Design, figmaurl.Proto, figmaurl.Board, figmaurl.Slides, figmaurl.Make:
+	default:
+		return &ValidationError{Field: "type", Value: string(d.Type)}
+	}
+	if err := ValidateFileKey(d.FileKey); err != nil {
+		return err
+	}
+	if d.BranchKey != "" && !fileKeyPattern.MatchString(d.BranchKey) {
+		return &ValidationError{Field: "branchKey", Value: d.BranchKey}
+	}
+	// Links without a file name slug still open the file
+	if d.FileName != "" {
+		if err := ValidateFileName(d.FileName); err != nil {
+			return err
+		}
+	}
+	if d.NodeId != "" {
+		if err := ValidateNodeId(d.NodeId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// virtualTool is a tool implemented by the proxy itself. It is listed alongside
+// the upstream tools and its tool calls never reach the Figma MCP server.
+type virtualTool struct {
+	Name        string
+	Description string
+	InputSchema map[string]interface{}
+	Call        func(tc *toolCall) (toolResult, error)
+}
+
+// toolCall is a call to a virtual tool.
+type toolCall struct {
+	ctx       context.Context
+	reqID     string
+	sessionID string
+	params    *jsonrpc.ToolCallParams
+}
+
+// toolCallError is returned by a virtual tool for calls it refuses, such as
+// ones with arguments it cannot use, and is answered with a JSON-RPC error of
+// its code. Any other error is an internal error; failures the model should see
+// are returned as error results.
+type toolCallError struct {
+	code int
+	msg  string
+}
+
+func (e *toolCallError) Error() string {
+	return e.msg
+}
+
+func invalidParams(format string, args ...interface{}) error {
+	return &toolCallError{code: jsonrpc.CodeInvalidParams, msg: fmt.Sprintf(format, args...)}
+}
+
+// virtualTools is the registry of proxy tools, in the order they are listed.
+type virtualTools struct {
+	tools  []*virtualTool
+	byName map[string]*virtualTool
+}
+
+func newVirtualTools(tools ...*virtualTool) *virtualTools {
+	v := &virtualTools{byName: make(map[string]*virtualTool)}
+	for _, tool := range tools {
+		v.register(tool)
+	}
+	return v
+}
+
+func (v *virtualTools) register(tool *virtualTool) {
+	if _, exists := v.byName[tool.Name]; exists {
+		panic("duplicate virtual tool " + tool.Name)
+	}
+	v.tools = append(v.tools, tool)
+	v.byName[tool.Name] = tool
+}
+
+func (v *virtualTools) lookup(name string) (*virtualTool, bool) {
+	tool, ok := v.byName[name]
+	return tool, ok
+}
+
+// definitions returns the tools/list entries of the virtual tools.
+func (v *virtualTools) definitions() []interface{} {
+	definitions := make([]interface{}, 0, len(v.tools))
+	for _, tool := range v.tools {
+		definitions = append(definitions, map[string]interface{}{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"inputSchema": tool.InputSchema,
+		})
+	}
+	return definitions
+}
+
+// serve answers a tools/call for a virtual tool locally.
+func (v *virtualTools) serve(w http.ResponseWriter, r *http.Request, msg *jsonrpc.Message, tool *virtualTool, params *jsonrpc.ToolCallParams) {
+	reqID := getRequestID(r)
+	log.Printf("[VIRTUAL_TOOL] [%s] Calling proxy tool %s", reqID, tool.Name)
+	result, err := tool.Call(&toolCall{
+		ctx:       r.Context(),
+		reqID:     reqID,
+		sessionID: r.Header.Get("Mcp-Session-Id"),
+		params:    params,
+	})
+	var callErr *toolCallError
+	switch {
+	case errors.As(err, &callErr):
+		writeRPCError(w, r, reqID, msg.ID, callErr.code, err.Error())
+	case err != nil:
+		writeRPCError(w, r, reqID, msg.ID, jsonrpc.CodeInternalError, err.Error())
+	default:
+		writeRPCResult(w, r, reqID, msg.ID, result)
+	}
+}
+
+// jsonToolResult returns a tool result with both a JSON text block and
+// structured content, for tools that report data.
+func jsonToolResult(value interface{}) (toolResult, error) {
+	text, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return toolResult{}, err
+	}
+	result := textToolResult(string(text), false)
+	result.StructuredContent = value
+	return result, nil
+}
+
+// designArgumentProperties are the schema properties of tools that take a design.
+var designArgumentProperties = map[string]interface{}{
+	"figmaUrl": map[string]interface{}{
+		"type":        "string",
+		"description": "The full Figma URL of the file or node, for example https://figma.com/design/1234/5678?node-id=1-2. Takes precedence over fileKey and fileName.",
+	},
+	"fileKey": map[string]interface{}{
+		"type":        "string",
+		"description": "The key of the file, for example `1234` in https://figma.com/design/1234/5678.",
+	},
+	"fileName": map[string]interface{}{
+		"type":        "string",
+		"description": "The name of the file, for example `5678` in https://figma.com/design/1234/5678.",
+	},
+}
+
+// designFromArguments reads the design a virtual tool is called with, from a
+// figmaUrl or from fileKey, fileName and an optional nodeId, and checks that the
+// caller's API key may use it.
+func designFromArguments(tc *toolCall) (util.Design, error) {
+	params := tc.params
+	var design util.Design
+	if figmaURL, ok := params.StringArgument("figmaUrl"); ok && figmaURL != "" {
+		link, err := figmaurl.Parse(figmaURL)
+		if err != nil {
+			return util.Design{}, invalidParams("invalid figmaUrl: %v", err)
+		}
+		design = util.DesignFromLink(link)
+	} else {
+		design.FileKey, _ = params.StringArgument("fileKey")
+		design.FileName, _ = params.StringArgument("fileName")
+	}
+	if nodeId, ok := params.StringArgument("nodeId"); ok && nodeId != "" {
+		design.NodeId = figmaurl.NormalizeNodeId(nodeId)
+	}
+	if design.FileKey == "" {
+		return util.Design{}, invalidParams("figmaUrl, or fileKey and fileName, are required")
+	}
+	if err := design.Validate(); err != nil {
+		return util.Design{}, invalidParams("%v", err)
+	}
+	if !requestKey(tc.ctx).AllowsFile(design.FileKey) {
+		log.Printf("[VIRTUAL_TOOL] [%s] File %s is not allowed for this API key", tc.reqID, design.FileKey)
+		return util.Design{}, &toolCallError{code: codeFileForbidden, msg: fmt.Sprintf("file %q is not allowed", design.FileKey)}
+	}
+	return design, nil
+}
+
+// designInfo describes a design in tool results.
+type designInfo struct {
+	Type      figmaurl.LinkType `json:"type"`
+	FileKey   string            `json:"fileKey"`
+	FileName  string            `json:"fileName,omitempty"`
+	BranchKey string            `json:"branchKey,omitempty"`
+	NodeId    string            `json:"nodeId,omitempty"`
+	URL       string            `json:"url"`
+	DeepLink  string            `json:"deepLink"`
+}
+
+func newDesignInfo(design util.Design) *designInfo {
+	return &designInfo{
+		Type:      design.LinkType(),
+		FileKey:   design.FileKey,
+		FileName:  design.FileName,
+		BranchKey: design.BranchKey,
+		NodeId:    design.NodeId,
+		URL:       design.WebURL(),
+		DeepLink:  design.URL(),
+	}
+}
+
+// newProxyTools returns the proxy's built-in virtual tools.
+func newProxyTools(switcher *designSwitcher, sessions *sessionDesigns) *virtualTools {
+	return newVirtualTools(
+		openFigmaDesignTool(switcher, sessions),
+		getActiveDesignTool(switcher, sessions),
+		parseFigmaURLTool(),
+		setCurrentDesignTool(sessions),
+	)
+}
+
+// openFigmaDesignTool switches Figma desktop to a design right away and makes it
+// the session's current design.
+func openFigmaDesignTool(switcher *designSwitcher, sessions *sessionDesigns) *virtualTool {
+	properties := map[string]interface{}{
+		"nodeId": map[string]interface{}{
+			"type":        "string",
+			"description": "An optional node to navigate to, for example `1:2`.",
+		},
+	}
+	for name, property := range designArgumentProperties {
+		properties[name] = property
+	}
+	return &virtualTool{
+		Name:        "open_figma_design",
+		Description: "Open a Figma file in Figma desktop and make it the current file for later tool calls in this session. Pass the Figma URL as figmaUrl, or the fileKey and fileName.",
+		InputSchema: map[string]interface{}{"type": "object", "properties": properties},
+		Call: func(tc *toolCall) (toolResult, error) {
+			design, err := designFromArguments(tc)
+			if err != nil {
+				return toolResult{}, err
+			}
+
+			if err := acquireDesignFileLock(tc.ctx); err != nil {
+				return toolResult{}, err
+			}
+			err = switcher.ensureOpen(tc.ctx, tc.reqID, design)
+			releaseDesignFileLock()
+			if err != nil {
+				return textToolResult(fmt.Sprintf("Failed to open %s: %v", design.WebURL(), err), true), nil
+			}
+			sessions.set(tc.sessionID, design)
+			return textToolResult(fmt.Sprintf("Opened %s in Figma.", design.WebURL()), false), nil
+		},
+	}
+}
+
+// getActiveDesignTool reports the design open in Figma desktop and the
+// session's current design.
+func getActiveDesignTool(switcher *designSwitcher, sessions *sessionDesigns) *virtualTool {
+	return &virtualTool{
+		Name:        "get_active_design",
+		Description: "Get the Figma file that is currently open in Figma desktop, and the current file of this session that tool calls without figmaUrl, fileKey and fileName use.",
+		InputSchema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+		Call: func(tc *toolCall) (toolResult, error) {
+			var info struct {
+				Active  *designInfo `json:"active"`
+				Session *designInfo `json:"session"`
+			}
+			// The active design may belong to a file the caller's key may not see
+			if design, ok := switcher.active.get(); ok && requestKey(tc.ctx).AllowsFile(design.FileKey) {
+				info.Active = newDesignInfo(design)
+			}
+			if design, ok := sessions.current(tc.sessionID); ok {
+				info.Session = newDesignInfo(design)
+			}
+			return jsonToolResult(info)
+		},
+	}
+}
+
+// parseFigmaURLTool parses a Figma link into its parts.
+func parseFigmaURLTool() *virtualTool {
+	return &virtualTool{
+		Name:        "parse_figma_url",
+		Description: "Parse a Figma URL into its link type, fileKey, fileName, branchKey and nodeId.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"url": map[string]interface{}{
+					"type":        "string",
+					"description": "The Figma URL, for example https://figma.com/design/1234/5678?node-id=1-2.",
+				},
+			},
+			"required": []string{"url"},
+		},
+		Call: func(tc *toolCall) (toolResult, error) {
+			raw, _ := tc.params.StringArgument("url")
+			if raw == "" {
+				return toolResult{}, invalidParams("url is required")
+			}
+			link, err := figmaurl.Parse(raw)
+			if err != nil {
+				return textToolResult(err.Error(), true), nil
+			}
+			return jsonToolResult(newDesignInfo(util.DesignFromLink(link)))
+		},
+	}
+}
+
+// setCurrentDesignTool sets or clears the session's current design.
+func setCurrentDesignTool(sessions *sessionDesigns) *virtualTool {
+	properties := map[string]interface{}{
+		"clear": map[string]interface{}{
+			"type":        "boolean",
+			"description": "Clear the current file instead of setting it.",
+		},
+	}
+	for name, property := range designArgumentProperties {
+		properties[name] = property
+	}
+	return &virtualTool{
+		Name:        "set_current_design",
+		Description: "Set the Figma file that later tool calls in this session use when they are called without figmaUrl, fileKey and fileName, or clear it. Pass the Figma URL as figmaUrl, or the fileKey and fileName, or clear=true.",
+		InputSchema: map[string]interface{}{"type": "object", "properties": properties},
+		Call: func(tc *toolCall) (toolResult, error) {
+			if tc.sessionID == "" {
+				return textToolResult("This client has no MCP session, so a current file cannot be remembered. Pass figmaUrl with every tool call instead.", true), nil
+			}
+			if clear, _ := tc.params.Arguments["clear"].(bool); clear {
+				sessions.clear(tc.sessionID)
+				log.Printf("[SESSION] [%s] Cleared session %s current design", tc.reqID, tc.sessionID)
+				return textToolResult("Cleared the current Figma file.", false), nil
+			}
+
+			design, err := designFromArguments(tc)
+			if err != nil {
+				return toolResult{}, err
+			}
+			sessions.set(tc.sessionID, design)
+			design.NodeId = ""
+			log.Printf("[SESSION] [%s] Session %s current design set to %s", tc.reqID, tc.sessionID, design)
+			return textToolResult(fmt.Sprintf("The current Figma file is now %s.", design.WebURL()), false), nil
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitovi/figma-mcp-proxy/figmaurl"
+	"github.com/bitovi/figma-mcp-proxy/jsonrpc"
+	"github.com/bitovi/figma-mcp-proxy/keystore"
+	"github.com/bitovi/figma-mcp-proxy/util"
+)
+
+// proxyToolsTest is the proxy tools with a recording opener and designs that
+// are ready immediately.
+type proxyToolsTest struct {
+	t        *testing.T
+	opener   *util.RecordingOpener
+	switcher *designSwitcher
+	sessions *sessionDesigns
+	tools    *virtualTools
+	// key is the API key calls are made with, nil for none
+	key *keystore.Key
+}
+
+func newProxyToolsTest(t *testing.T) *proxyToolsTest {
+	opener := &util.RecordingOpener{}
+	switcher := &designSwitcher{
+		opener: opener,
+		active: newActiveDesign(NodeChangeSkip),
+		waiter: &util.ReadinessWaiter{Probe: readyProbe{}, Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}
+	sessions := newSessionDesigns(time.Hour)
+	return &proxyToolsTest{
+		t:        t,
+		opener:   opener,
+		switcher: switcher,
+		sessions: sessions,
+		tools:    newProxyTools(switcher, sessions),
+	}
+}
+
+// call serves a tools/call of a proxy tool in session-1 and decodes the
+// response.
+func (p *proxyToolsTest) call(name string, arguments map[string]interface{}) rpcResponse {
+	p.t.Helper()
+	req := newToolCallRequest(1, name, arguments)
+	req.Header.Set("Mcp-Session-Id", "session-1")
+	if p.key != nil {
+		req = req.WithContext(withAPIKey(req.Context(), p.key))
+	}
+	body, _ := io.ReadAll(req.Body)
+	payload, err := jsonrpc.Parse(body)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	msg := payload.Messages[0]
+	params, _, err := msg.ToolCall()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	tool, ok := p.tools.lookup(name)
+	if !ok {
+		p.t.Fatalf("no proxy tool %s", name)
+	}
+
+	rw := httptest.NewRecorder()
+	p.tools.serve(rw, req, msg, tool, params)
+	return decodeRPCResponse(p.t, rw.Body.Bytes())
+}
+
+// wantError fails the test unless resp is a JSON-RPC error with code.
+func wantError(t *testing.T, resp rpcResponse, code int) {
+	t.Helper()
+	if resp.Error == nil || resp.Error.Code != code {
+		t.Fatalf("response = result %+v, error %+v, want error %d", resp.Result, resp.Error, code)
+	}
+}
+
+// wantResult fails the test unless resp is a tool result with the isError flag
+// and a text block containing text.
+func wantResult(t *testing.T, resp rpcResponse, isError bool, text string) {
+	t.Helper()
+	if resp.Result == nil {
+		t.Fatalf("response = error %+v, want a result", resp.Error)
+	}
+	if resp.Result.IsError != isError {
+		t.Errorf("isError = %v, want %v", resp.Result.IsError, isError)
+	}
+	if len(resp.Result.Content) != 1 || resp.Result.Content[0].Type != "text" || !strings.Contains(resp.Result.Content[0].Text, text) {
+		t.Errorf("content = %+v, want one text block containing %q", resp.Result.Content, text)
+	}
+}
+
+func TestOpenFigmaDesignTool(t *testing.T) {
+	p := newProxyToolsTest(t)
+
+	resp := p.call("open_figma_design", map[string]interface{}{"figmaUrl": "https://www.figma.com/design/abc123/My-File?node-id=1-2"})
+	wantResult(t, resp, false, "Opened https://www.figma.com/design/abc123/My-File?node-id=1-2")
+	want := util.Design{Type: figmaurl.Design, FileKey: "abc123", FileName: "My-File", NodeId: "1:2"}
+	if designs := p.opener.Designs(); len(designs) != 1 || designs[0] != want {
+		t.Errorf("opened %+v, want %+v", designs, want)
+	}
+	want.NodeId = ""
+	if current, ok := p.sessions.current("session-1"); !ok || current != want {
+		t.Errorf("session design = %+v, %v, want %+v", current, ok, want)
+	}
+
+	resp = p.call("open_figma_design", map[string]interface{}{"fileKey": "def456", "fileName": "Other", "nodeId": "3-4"})
+	wantResult(t, resp, false, "Opened https://www.figma.com/design/def456/Other?node-id=3-4")
+}
+
+func TestOpenFigmaDesignToolOpenFailure(t *testing.T) {
+	p := newProxyToolsTest(t)
+	p.opener.Err = errors.New("no Figma desktop")
+
+	resp := p.call("open_figma_design", map[string]interface{}{"fileKey": "abc123", "fileName": "My-File"})
+	wantResult(t, resp, true, "no Figma desktop")
+	if _, ok := p.sessions.current("session-1"); ok {
+		t.Error("a design that failed to open became the session design")
+	}
+}
+
+func TestOpenFigmaDesignToolInvalidArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+	}{
+		{name: "no design", arguments: map[string]interface{}{}},
+		{name: "nodeId only", arguments: map[string]interface{}{"nodeId": "1:2"}},
+		{name: "invalid figmaUrl", arguments: map[string]interface{}{"figmaUrl": "https://example.com/design/abc123"}},
+		{name: "invalid fileName", arguments: map[string]interface{}{"fileKey": "abc123", "fileName": "My File"}},
+		{name: "invalid nodeId", arguments: map[string]interface{}{"fileKey": "abc123", "fileName": "My-File", "nodeId": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProxyToolsTest(t)
+			wantError(t, p.call("open_figma_design", tt.arguments), jsonrpc.CodeInvalidParams)
+			if designs := p.opener.Designs(); len(designs) != 0 {
+				t.Errorf("opened %+v for invalid arguments", designs)
+			}
+		})
+	}
+}
+
+func TestOpenFigmaDesignToolFileScope(t *testing.T) {
+	p := newProxyToolsTest(t)
+	p.key = &keystore.Key{Name: "scoped", Scopes: keystore.Scopes{Files: []string{"abc*"}}}
+
+	wantResult(t, p.call("open_figma_design", map[string]interface{}{"fileKey": "abc123", "fileName": "My-File"}), false, "Opened")
+	wantError(t, p.call("open_figma_design", map[string]interface{}{"fileKey": "def456", "fileName": "Other"}), codeFileForbidden)
+	if designs := p.opener.Designs(); len(designs) != 1 {
+		t.Errorf("opened %+v, want only the allowed file", designs)
+	}
+}
+
+func TestGetActiveDesignTool(t *testing.T) {
+	p := newProxyToolsTest(t)
+	activeDesign := func(resp rpcResponse) (active, session *designInfo) {
+		t.Helper()
+		wantResult(t, resp, false, "")
+		var info struct {
+			Active  *designInfo `json:"active"`
+			Session *designInfo `json:"session"`
+		}
+		if err := json.Unmarshal(resp.Result.StructuredContent, &info); err != nil {
+			t.Fatalf("structuredContent: %v", err)
+		}
+		return info.Active, info.Session
+	}
+
+	if active, session := activeDesign(p.call("get_active_design", nil)); active != nil || session != nil {
+		t.Errorf("get_active_design = %+v, %+v before anything was opened, want none", active, session)
+	}
+
+	p.call("open_figma_design", map[string]interface{}{"figmaUrl": "https://www.figma.com/design/abc123/My-File?node-id=1-2"})
+	active, session := activeDesign(p.call("get_active_design", nil))
+	if active == nil || active.FileKey != "abc123" || active.NodeId != "1:2" || active.DeepLink != "figma://design/abc123/My-File?node-id=1-2" {
+		t.Errorf("active = %+v, want abc123 at node 1:2", active)
+	}
+	if session == nil || session.FileKey != "abc123" || session.NodeId != "" {
+		t.Errorf("session = %+v, want abc123 without a node", session)
+	}
+
+	// A key scoped to other files does not see the active design
+	p.key = &keystore.Key{Name: "scoped", Scopes: keystore.Scopes{Files: []string{"def456"}}}
+	if active, _ := activeDesign(p.call("get_active_design", nil)); active != nil {
+		t.Errorf("active = %+v for a key scoped to another file, want none", active)
+	}
+}
+
+func TestParseFigmaURLTool(t *testing.T) {
+	p := newProxyToolsTest(t)
+
+	resp := p.call("parse_figma_url", map[string]interface{}{"url": "https://www.figma.com/design/abc123/branch/def456/My-File?node-id=1-2"})
+	wantResult(t, resp, false, `"branchKey": "def456"`)
+	var info designInfo
+	if err := json.Unmarshal(resp.Result.StructuredContent, &info); err != nil {
+		t.Fatalf("structuredContent: %v", err)
+	}
+	want := designInfo{
+		Type:      figmaurl.Design,
+		FileKey:   "abc123",
+		FileName:  "My-File",
+		BranchKey: "def456",
+		NodeId:    "1:2",
+		URL:       "https://www.figma.com/design/abc123/branch/def456/My-File?node-id=1-2",
+		DeepLink:  "figma://design/abc123/branch/def456/My-File?node-id=1-2",
+	}
+	if info != want {
+		t.Errorf("parse_figma_url = %+v, want %+v", info, want)
+	}
+
+	wantResult(t, p.call("parse_figma_url", map[string]interface{}{"url": "https://example.com/design/abc123"}), true, "not a Figma URL")
+	wantError(t, p.call("parse_figma_url", map[string]interface{}{}), jsonrpc.CodeInvalidParams)
+	if designs := p.opener.Designs(); len(designs) != 0 {
+		t.Errorf("parse_figma_url opened %+v", designs)
+	}
+}